@@ -39,7 +39,7 @@ func DoConnectionLost(conn ziface.IConnection) {
 		zlog.Error("Conn Property Home = ", home)
 	}
 
-	zlog.Debug("DoConneciotnLost is Called ... ")
+	zlog.Debug("DoConneciotnLost is Called ... reason = ", conn.CloseReason())
 }
 
 func main() {
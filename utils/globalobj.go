@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/dokidokikoi/my-zinx/utils/commandline/args"
 	"io/ioutil"
+	"math"
 	"os"
 
 	"github.com/dokidokikoi/my-zinx/ziface"
@@ -24,11 +25,97 @@ type GlobalObj struct {
 	MaxPacketSize uint32
 	// 当前服务器主机允许的最大连接个数
 	MaxConn int
+	// 连接数达到 MaxConn 时的处理策略: reject(拒绝新连接)、evict_idle(踢掉空闲最久的连接)、
+	// evict_unauth(优先踢掉未绑定用户的连接中空闲最久的，没有时按 evict_idle 处理)
+	MaxConnPolicy string
+	// 连接管理器的分片数，大于 1 时使用分片的连接管理器
+	ConnMgrShardCount int
 	// 业务工作池的数量
 	WorkerPoolSize uint32
-	// 业务工作 worker 对应任务队列的最大任务存储数量
+	// 业务工作 worker 对应任务队列的最大任务存储数量，
+	// 开启 OrderedMsgHandle 时也是每个连接待处理请求的上限，达到上限时暂停读取该连接
 	MaxWorkerTaskLen uint32
 	MaxMsgChanLen    uint32
+	// 未开启工作池时，是否保证同一连接的消息按到达顺序处理
+	OrderedMsgHandle bool
+	// 每个连接读缓冲的大小
+	IOReadBuffSize uint32
+	// 单个请求的处理超时时间(毫秒)，从开始执行处理方法时计算，不包括在任务队列中等待的时间，
+	// 超时后请求的 context 被取消，0 表示不限制
+	RequestTimeout uint32
+
+	// 关闭连接时发送剩余消息的最长等待时间(毫秒)
+	CloseFlushTimeout uint32
+	// 关闭连接前是否向客户端发送关闭帧
+	SendCloseFrame bool
+	// 关闭帧使用的保留 MsgID
+	CloseFrameMsgID uint32
+
+	// 每个连接每秒允许接收的消息数和字节数，0 表示不限制
+	MaxMsgPerSec   uint32
+	MaxBytesPerSec uint32
+	// 针对单个 MsgID 的限流，与连接级别的限流同时生效
+	MsgRateLimits map[uint32]RateLimit
+	// 超过限流时的处理策略: drop, delay, error, disconnect
+	RateLimitPolicy string
+	// disconnect 策略下断开连接前允许的违规次数
+	RateLimitMaxStrikes uint32
+	// 违规次数的统计窗口(毫秒)，距上次违规超过该时长后重新计数，0 表示一直累计
+	RateLimitStrikeWindow uint32
+	// error 策略下错误帧使用的 MsgID
+	RateLimitErrMsgID uint32
+
+	// 输出队列长度的高水位，0 表示不检测慢消费者
+	SlowConsumerHighWater uint32
+	// 输出队列持续高于高水位多久(毫秒)后将连接标记为慢消费者
+	SlowConsumerThreshold uint32
+	// 慢消费者的处理策略: skip, disconnect
+	SlowConsumerPolicy string
+	// skip 策略下仍然需要发送的关键消息
+	CriticalMsgIDs []uint32
+
+	// 同一用户重复登录时的处理策略: kick, reject, multi
+	DuplicateLoginPolicy string
+
+	// 是否开启断线重连后的会话恢复
+	ResumeEnable bool
+	// 每个会话最多保留的未确认消息数
+	ResumeReplayLen uint32
+	// 连接断开后会话的保留时间(毫秒)
+	ResumeTTL uint32
+	// 下发恢复令牌、会话恢复请求及结果、消息确认使用的 MsgID
+	ResumeTokenMsgID uint32
+	ResumeMsgID      uint32
+	ResumeAckMsgID   uint32
+
+	// 是否开启离线消息
+	OutboxEnable bool
+	// 离线消息的存储方式: memory(内存)、file(OutboxDir 目录下的文件)
+	OutboxStore string
+	OutboxDir   string
+	// 每个用户最多保存的离线消息数和消息体字节数，0 表示不限制
+	OutboxMaxMsgs  uint32
+	OutboxMaxBytes uint32
+	// 离线消息的保存时间(毫秒)，0 表示不过期
+	OutboxTTL uint32
+
+	// 开启会话恢复时同步连接属性的会话存储: 空(不同步)、memory(内存)、file(SessionStoreDir 目录下的文件)
+	SessionStore    string
+	SessionStoreDir string
+
+	// 当前节点在集群中的 ID
+	ClusterNodeID uint32
+	// 集群总线监听的地址和端口，端口为 0 时不开启集群，默认只监听本机
+	ClusterHost string
+	ClusterPort int
+	// 节点之间握手认证使用的共享密钥，所有节点必须相同
+	ClusterSecret string
+	// 集群中的其他节点，只接受这些节点的连接
+	ClusterPeers []ClusterPeer
+	// 跨节点发送等待结果的超时时间(毫秒)，同时也是总线连接建立连接和完成握手的超时时间
+	ClusterTimeout uint32
+	// 与其他节点断开后重新连接的间隔(毫秒)
+	ClusterRetryInterval uint32
 
 	ConfFilePath string
 
@@ -40,6 +127,22 @@ type GlobalObj struct {
 	LogDebugClose bool
 }
 
+// 限流参数，0 表示不限制
+type RateLimit struct {
+	// 每秒允许的消息数
+	MsgPerSec uint32
+	// 每秒允许的字节数
+	BytesPerSec uint32
+}
+
+// 集群中的一个节点
+type ClusterPeer struct {
+	// 节点 ID
+	NodeID uint32
+	// 节点集群总线的地址，如 127.0.0.1:9000
+	Addr string
+}
+
 var GlobalObject *GlobalObj
 
 func PathExists(path string) (bool, error) {
@@ -84,19 +187,47 @@ func init() {
 	args.FlagHandle()
 	// 初始化 GlobalObject 变量，设置一些默认值
 	GlobalObject = &GlobalObj{
-		Name:             "ZinxServerApp",
-		Version:          "v0.10",
-		TcpPort:          7777,
-		Host:             "0.0.0.0",
-		MaxPacketSize:    4096,
-		ConfFilePath:     args.Args.ConfigFile,
-		WorkerPoolSize:   10,
-		MaxWorkerTaskLen: 1024,
-		MaxMsgChanLen:    1024,
-		MaxConn:          12000,
-		LogDir:           pwd + "/log",
-		LogFile:          "",
-		LogDebugClose:    false,
+		Name:                  "ZinxServerApp",
+		Version:               "v0.10",
+		TcpPort:               7777,
+		Host:                  "0.0.0.0",
+		MaxPacketSize:         4096,
+		ConfFilePath:          args.Args.ConfigFile,
+		WorkerPoolSize:        10,
+		MaxWorkerTaskLen:      1024,
+		MaxMsgChanLen:         1024,
+		IOReadBuffSize:        4096,
+		OrderedMsgHandle:      false,
+		CloseFlushTimeout:     1000,
+		SendCloseFrame:        false,
+		CloseFrameMsgID:       math.MaxUint32,
+		DuplicateLoginPolicy:  "kick",
+		RateLimitPolicy:       "drop",
+		RateLimitMaxStrikes:   3,
+		RateLimitStrikeWindow: 60000,
+		SlowConsumerHighWater: 0,
+		SlowConsumerThreshold: 3000,
+		SlowConsumerPolicy:    "skip",
+		RateLimitErrMsgID:     math.MaxUint32 - 1,
+		ResumeReplayLen:       256,
+		ResumeTTL:             60000,
+		ResumeTokenMsgID:      math.MaxUint32 - 2,
+		ResumeMsgID:           math.MaxUint32 - 3,
+		ResumeAckMsgID:        math.MaxUint32 - 4,
+		OutboxStore:           "memory",
+		OutboxDir:             "./outbox",
+		OutboxMaxMsgs:         1000,
+		OutboxMaxBytes:        1 << 20,
+		OutboxTTL:             86400000,
+		SessionStoreDir:       "./session",
+		ClusterHost:           "127.0.0.1",
+		ClusterTimeout:        3000,
+		ClusterRetryInterval:  1000,
+		MaxConn:               12000,
+		MaxConnPolicy:         "reject",
+		LogDir:                pwd + "/log",
+		LogFile:               "",
+		LogDebugClose:         false,
 	}
 
 	// 从配置文件加载用户配置
@@ -0,0 +1,73 @@
+package ziface
+
+import "fmt"
+
+// 连接关闭原因码，会随关闭帧发送给客户端
+type CloseCode uint32
+
+const (
+	// 连接尚未关闭
+	CloseNone CloseCode = iota
+	// 服务端正常关闭连接
+	CloseNormal
+	// 客户端主动断开连接
+	CloseClientQuit
+	// 连接读写超时
+	CloseTimeout
+	// 连接被踢下线
+	CloseKicked
+	// 拆包失败
+	CloseUnpackError
+	// 向客户端写数据失败
+	CloseWriteError
+	// 服务器关闭
+	CloseServerShutdown
+	// 超出限流次数
+	CloseRateLimited
+	// 客户端读取过慢
+	CloseSlowConsumer
+	// 连接数达到上限时被踢掉
+	CloseEvicted
+	// 未通过认证
+	CloseUnauthorized
+)
+
+// 用户自定义的关闭原因码应从该值开始
+const CloseUserDefined CloseCode = 1000
+
+var closeCodeNames = map[CloseCode]string{
+	CloseNone:           "none",
+	CloseNormal:         "normal",
+	CloseClientQuit:     "client quit",
+	CloseTimeout:        "timeout",
+	CloseKicked:         "kicked",
+	CloseUnpackError:    "unpack error",
+	CloseWriteError:     "write error",
+	CloseServerShutdown: "server shutdown",
+	CloseRateLimited:    "rate limited",
+	CloseSlowConsumer:   "slow consumer",
+	CloseEvicted:        "evicted",
+	CloseUnauthorized:   "unauthorized",
+}
+
+func (c CloseCode) String() string {
+	if name, ok := closeCodeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("close code %d", uint32(c))
+}
+
+// 连接关闭原因
+type CloseReason struct {
+	// 关闭原因码
+	Code CloseCode
+	// 导致关闭的错误或说明，可以为 nil
+	Err error
+}
+
+func (r CloseReason) String() string {
+	if r.Err == nil {
+		return r.Code.String()
+	}
+	return r.Code.String() + ": " + r.Err.Error()
+}
@@ -0,0 +1,25 @@
+package ziface
+
+// 跨节点发送到一个节点的结果
+type ClusterResult struct {
+	NodeID uint32
+	// 该节点上成功放入发送队列的连接数
+	Delivered int
+	// 发送失败的原因
+	Err error
+}
+
+// 集群总线，将发送请求路由到连接或用户所在的节点
+type ICluster interface {
+	// 当前节点的 ID
+	NodeID() uint32
+	// 获取当前已连通的其他节点
+	Nodes() []uint32
+	// 向节点 nodeID 上的连接发送消息，nodeID 为当前节点时直接发送
+	SendToConn(nodeID uint32, connID uint32, msgID uint32, data []byte) error
+	// 向用户发送消息，用户所在的节点由各节点同步的在线信息确定
+	// 至少发送到用户的一个连接时返回 nil
+	SendToUser(user string, msgID uint32, data []byte) error
+	// 向所有节点上的分组成员广播消息，返回每个节点的结果
+	SendToGroup(group string, msgID uint32, data []byte) []ClusterResult
+}
@@ -0,0 +1,23 @@
+package ziface
+
+import (
+	"bufio"
+	"io"
+)
+
+// 消息编解码器，直接从连接的读缓冲中解码出完整的消息
+// 相比固定长度头部的 IPacket，可以实现变长头部、分隔符等格式
+type IFrameCodec interface {
+	// 从 r 中读取并解码一条完整的消息
+	// 连接断开等读取错误原样返回，格式错误返回其他错误，连接会以拆包失败关闭
+	Decode(r *bufio.Reader) (IMessage, error)
+	// 将消息编码后写入 w
+	Encode(w io.Writer, msg IMessage) error
+}
+
+// 支持追加式编码的 Codec，连接发送和广播消息时直接编码到预分配的切片中
+type IAppendCodec interface {
+	IFrameCodec
+	// 将 msg 编码后追加到 dst 尾部，返回追加后的切片
+	AppendEncode(dst []byte, msg IMessage) ([]byte, error)
+}
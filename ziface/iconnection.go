@@ -1,6 +1,9 @@
 package ziface
 
-import "net"
+import (
+	"context"
+	"net"
+)
 
 // 连接接口
 type IConnection interface {
@@ -8,6 +11,12 @@ type IConnection interface {
 	Start()
 	// 停止连接，结束当前连接状态
 	Stop()
+	// 携带关闭原因停止连接，只有第一次设置的原因会生效
+	StopWithReason(code CloseCode, err error)
+	// 获取连接的关闭原因，连接未关闭时 Code 为 CloseNone
+	CloseReason() CloseReason
+	// 获取连接的 context，连接关闭或服务器停止时被取消
+	Context() context.Context
 	// 从当前连接获取原始的 socket TCPConn
 	GetTCPConnection() *net.TCPConn
 	// 获取当前连接 ID
@@ -18,13 +27,23 @@ type IConnection interface {
 	SendMsg(msgID uint32, data []byte) error
 	// 直接将 Message 数据发送给远程的 TCP 客户端(带缓冲)
 	SendBuffMsg(msgID uint32, data []byte) error
+	// 将已经封包好的消息以非阻塞的方式放入发送队列，用于广播时共享封包结果
+	SendPackedBuffMsg(msgID uint32, packed []byte) error
+	// 获取连接的流量统计信息
+	Stats() ConnStats
 
 	// 设置连接属性
 	SetProperty(key string, value interface{})
 	// 获取连接属性
 	GetProperty(key string) (interface{}, error)
+	// 获取全部连接属性的副本
+	GetProperties() map[string]interface{}
 	// 移除连接属性
 	RemoveProperty(key string)
+	// 原子地更新连接属性，返回更新后的值以及属性是否存在
+	UpdateProperty(key string, updater PropertyUpdater) (interface{}, bool)
+	// 监听连接属性的变化，key 为空时监听全部属性，返回取消监听的函数
+	WatchProperty(key string, watcher PropertyWatcher) (cancel func())
 }
 
 // 定义一个统一处理连接业务的接口
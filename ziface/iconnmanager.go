@@ -1,7 +1,7 @@
 package ziface
 
 type IConnManager interface {
-	// 添加连接
+	// 添加连接，同一 ConnID 再次添加时替换原来的连接
 	Add(conn IConnection)
 	// 移除连接
 	Remove(conn IConnection)
@@ -11,4 +11,30 @@ type IConnManager interface {
 	Len() int
 	// 删除并停止所有连接
 	ClearConn()
+	// 获取全部连接的流量汇总，包含已经关闭的连接
+	Stats() ConnMgrStats
+	// 遍历当前的全部连接，fn 返回 false 时停止遍历
+	// 遍历的是调用时的连接快照，fn 中可以安全地停止或移除连接
+	Range(fn func(conn IConnection) bool)
+	// 获取满足条件的连接
+	Filter(fn func(conn IConnection) bool) []IConnection
+	// 为连接属性建立二级索引，属性变化或连接移除时索引自动更新
+	AddIndex(property string)
+	// 通过属性索引查找属性值为 value 的连接
+	GetByIndex(property string, value interface{}) []IConnection
+
+	// 设置广播时使用的编解码器
+	SetCodec(codec IFrameCodec)
+	// 向全部连接广播消息，消息只封包一次
+	Broadcast(msgID uint32, data []byte) []SendResult
+	// 向指定 ConnID 的连接发送消息，消息只封包一次
+	Multicast(connIDs []uint32, msgID uint32, data []byte) []SendResult
+	// 向满足条件的连接广播消息，消息只封包一次
+	BroadcastFilter(fn func(conn IConnection) bool, msgID uint32, data []byte) []SendResult
+}
+
+// 广播时单个连接的发送结果，Err 为 nil 表示已放入该连接的发送队列
+type SendResult struct {
+	ConnID uint32
+	Err    error
 }
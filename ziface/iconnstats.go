@@ -0,0 +1,63 @@
+package ziface
+
+import "time"
+
+// 单个连接的流量统计信息
+type ConnStats struct {
+	// 读取和写出的字节数
+	BytesIn  uint64
+	BytesOut uint64
+	// 读取和写出的消息数
+	MsgsIn  uint64
+	MsgsOut uint64
+	// 建立连接的时间
+	ConnectTime time.Time
+	// 最后一次读取、写出消息的时间，尚未发生时为零值
+	LastReadTime  time.Time
+	LastWriteTime time.Time
+	// 当前输出队列中等待发送的消息数
+	QueueLen int
+	// 因输出队列已满或慢消费者策略而发送失败的消息数
+	DroppedSends uint64
+	// 是否被标记为慢消费者
+	Slow bool
+}
+
+// 整个连接管理器的流量汇总，包含已经关闭的连接
+type ConnMgrStats struct {
+	// 当前连接数
+	Conns int
+	// 累计连接数
+	TotalConns uint64
+	BytesIn    uint64
+	BytesOut   uint64
+	MsgsIn     uint64
+	MsgsOut    uint64
+	// 当前所有连接输出队列中等待发送的消息数
+	QueueLen     int
+	DroppedSends uint64
+	// 当前被标记为慢消费者的连接数
+	SlowConns int
+}
+
+// 将一个连接的统计信息累加到汇总中
+func (s *ConnMgrStats) Add(cs ConnStats) {
+	s.BytesIn += cs.BytesIn
+	s.BytesOut += cs.BytesOut
+	s.MsgsIn += cs.MsgsIn
+	s.MsgsOut += cs.MsgsOut
+	s.DroppedSends += cs.DroppedSends
+}
+
+// 合并另一份汇总
+func (s *ConnMgrStats) Merge(other ConnMgrStats) {
+	s.Conns += other.Conns
+	s.TotalConns += other.TotalConns
+	s.BytesIn += other.BytesIn
+	s.BytesOut += other.BytesOut
+	s.MsgsIn += other.MsgsIn
+	s.MsgsOut += other.MsgsOut
+	s.QueueLen += other.QueueLen
+	s.DroppedSends += other.DroppedSends
+	s.SlowConns += other.SlowConns
+}
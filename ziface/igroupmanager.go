@@ -0,0 +1,32 @@
+package ziface
+
+// 分组管理接口，连接可以加入多个命名分组(如聊天频道、房间)
+type IGroupManager interface {
+	// 将连接加入分组，连接已关闭时返回错误
+	Join(group string, conn IConnection) error
+	// 将连接移出分组
+	Leave(group string, conn IConnection)
+	// 将连接移出所有分组，连接关闭时自动调用
+	LeaveAll(conn IConnection)
+	// 获取分组的全部成员
+	Members(group string) []IConnection
+	// 获取分组的成员数量
+	Count(group string) int
+	// 判断连接是否在分组中
+	IsMember(group string, conn IConnection) bool
+	// 获取当前所有非空分组
+	Groups() []string
+	// 获取连接所在的全部分组
+	GroupsOf(conn IConnection) []string
+	// 向分组内的连接广播消息，跳过 exclude 中的 ConnID，消息只封包一次
+	Broadcast(group string, msgID uint32, data []byte, exclude ...uint32) []SendResult
+	// 设置广播时使用的编解码器
+	SetCodec(codec IFrameCodec)
+
+	// 设置连接加入分组时的 hook 函数
+	SetOnJoin(func(group string, conn IConnection))
+	// 设置连接离开分组时的 hook 函数
+	SetOnLeave(func(group string, conn IConnection))
+	// 设置分组变为空时的 hook 函数
+	SetOnEmpty(func(group string))
+}
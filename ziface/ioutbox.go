@@ -0,0 +1,43 @@
+package ziface
+
+import "time"
+
+// 离线消息
+type OutboxMsg struct {
+	MsgID uint32
+	Data  []byte
+	// 保存离线消息的时间，用于判断是否过期
+	Time time.Time
+	// 保存时由存储分配的序号，同一存储中递增，确认投递时按序号匹配
+	Seq uint64
+}
+
+// 离线消息的存储，需要保证同一用户的消息按保存顺序取出
+type IOutboxStore interface {
+	// 保存一条离线消息并分配序号，超出用户配额时返回错误
+	Push(user string, msg OutboxMsg) error
+	// 取出用户全部未过期的离线消息，取出后从存储中删除
+	Pop(user string) ([]OutboxMsg, error)
+	// 按顺序返回用户全部未过期的离线消息，不从存储中删除
+	Peek(user string) ([]OutboxMsg, error)
+	// 删除 Peek 返回的消息中已经投递的开头部分 msgs，按 Seq 匹配，期间已经过期清理的消息会被跳过
+	Ack(user string, msgs []OutboxMsg) error
+	// 用户当前保存的离线消息数，可能包含尚未清理的过期消息
+	Len(user string) int
+	// 清理 now 时已经过期的离线消息
+	Expire(now time.Time) error
+	// 关闭存储
+	Close() error
+}
+
+// 离线消息箱
+type IOutbox interface {
+	// 向用户发送消息，用户不在线时保存为离线消息，用户再次绑定连接时按顺序投递
+	SendToUser(user string, msgID uint32, data []byte) error
+	// 直接保存一条离线消息
+	Push(user string, msgID uint32, data []byte) error
+	// 用户当前保存的离线消息数
+	Len(user string) int
+	// 删除用户的全部离线消息
+	Clear(user string) error
+}
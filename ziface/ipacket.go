@@ -11,3 +11,11 @@ type IPacket interface {
 	// 拆包
 	Unpack([]byte) (IMessage, error)
 }
+
+// 支持追加式封包的 Packet，自定义的 Packet 实现该接口后，
+// 连接发送消息时会直接将消息追加到预分配的切片中，省去中间缓冲
+type IAppendPacket interface {
+	IPacket
+	// 将 msg 封包后追加到 dst 尾部，返回追加后的切片
+	AppendPack(dst []byte, msg IMessage) ([]byte, error)
+}
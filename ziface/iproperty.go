@@ -0,0 +1,31 @@
+package ziface
+
+// 连接属性的一次变化
+type PropertyChange struct {
+	// 属性名
+	Key string
+	// 变化前的值，OldExists 为 false 时表示之前不存在该属性
+	Old       interface{}
+	OldExists bool
+	// 变化后的值，NewExists 为 false 时表示该属性被移除
+	New       interface{}
+	NewExists bool
+}
+
+// 连接属性变化的监听函数，在属性修改完成后调用
+type PropertyWatcher func(conn IConnection, change PropertyChange)
+
+// 属性更新函数对属性采取的动作
+type PropertyAction int
+
+const (
+	// 保持属性不变
+	PropertyKeep PropertyAction = iota
+	// 将属性设置为返回的新值
+	PropertySet
+	// 移除属性
+	PropertyRemove
+)
+
+// 属性更新函数，根据旧值决定新值和动作，在持有属性锁时调用，不能再访问该连接的属性
+type PropertyUpdater func(old interface{}, exists bool) (value interface{}, action PropertyAction)
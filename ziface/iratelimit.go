@@ -0,0 +1,14 @@
+package ziface
+
+// 一次限流违规的信息，用于审计
+type RateLimitEvent struct {
+	// 违规消息的 MsgID 和数据长度
+	MsgID   uint32
+	DataLen uint32
+	// 是否由 MsgID 级别的限流触发，否则为连接级别的限流
+	PerMsgID bool
+	// 采取的处理策略
+	Policy string
+	// 当前连接在统计窗口内的违规次数
+	Strikes uint32
+}
@@ -0,0 +1,14 @@
+package ziface
+
+// 已发送消息的重放缓冲，用于断线重连后补发客户端没有收到的消息
+// 每条写出的消息按顺序编号，序号从 1 开始
+type IReplayBuffer interface {
+	// 记录一条已经写出的消息，返回它的序号
+	Record(frame []byte) uint64
+	// 客户端确认已经收到 seq 及之前的消息，这些消息不再保留
+	Ack(seq uint64)
+	// 最后一条消息的序号
+	LastSeq() uint64
+	// 获取序号大于 seq 的全部消息，所需的消息已经被丢弃时返回错误
+	Since(seq uint64) ([][]byte, error)
+}
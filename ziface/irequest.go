@@ -1,5 +1,7 @@
 package ziface
 
+import "context"
+
 // 将客户端请求的连接信息和请求的数据包装到 Request 里
 type IRequest interface {
 	// 获取请求连接信息
@@ -7,4 +9,9 @@ type IRequest interface {
 	// 获取请求消息的数据
 	GetData() []byte
 	GetMsgID() uint32
+	// 获取请求的 context，派生自连接的 context，连接关闭或请求超时时被取消
+	Context() context.Context
+	// 释放请求占用的资源，由框架在处理方法执行完毕后调用，
+	// 之后 GetData 返回的数据不再有效，需要异步使用时应自行拷贝
+	Release()
 }
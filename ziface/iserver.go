@@ -13,6 +13,14 @@ type IServer interface {
 	AddRouter(msgID uint32, router IRouter)
 	// 得到连接管理器
 	GetConnMgr() IConnManager
+	// 得到分组管理器
+	GetGroupMgr() IGroupManager
+	// 得到会话管理器
+	GetSessionMgr() ISessionManager
+	// 得到离线消息箱，未开启离线消息时为 nil
+	GetOutbox() IOutbox
+	// 得到集群总线，未开启集群时为 nil
+	GetCluster() ICluster
 	// 设置该 server 连接创建时的 hook 函数
 	SetOnConnStart(func(IConnection))
 	// 设置该 server 连接断开时的 hook 函数
@@ -21,5 +29,18 @@ type IServer interface {
 	CallOnConnStart(IConnection)
 	// 调用连接断开时的 hook 函数
 	CallOnConnStop(IConnection)
+	// 设置连接触发限流时的 hook 函数
+	SetOnRateLimit(func(IConnection, RateLimitEvent))
+	// 调用连接触发限流时的 hook 函数
+	CallOnRateLimit(IConnection, RateLimitEvent)
+	// 设置连接数达到上限、连接被踢掉时的 hook 函数
+	SetOnConnEvict(func(IConnection))
+	// 调用连接被踢掉时的 hook 函数
+	CallOnConnEvict(IConnection)
+	// 获取使用的 IPacket，使用其他 IFrameCodec 时返回只能用于封包的适配
+	//
+	// Deprecated: 使用 Codec 获取连接使用的编解码器
 	Packet() IPacket
+	// 获取连接使用的编解码器
+	Codec() IFrameCodec
 }
@@ -0,0 +1,20 @@
+package ziface
+
+// 会话管理接口，将用户身份绑定到连接
+type ISessionManager interface {
+	// 将用户绑定到连接，按配置的策略处理同一用户的重复登录
+	Bind(user string, conn IConnection) error
+	// 解除连接与用户的绑定，连接关闭时自动调用
+	Unbind(conn IConnection)
+	// 获取用户绑定的全部连接
+	GetByUser(user string) []IConnection
+	// 获取连接绑定的用户
+	UserOf(conn IConnection) (string, bool)
+	// 当前在线的用户数
+	Len() int
+
+	// 设置用户绑定到连接时的 hook 函数
+	SetOnBind(func(user string, conn IConnection))
+	// 设置用户与连接解除绑定时的 hook 函数
+	SetOnUnbind(func(user string, conn IConnection))
+}
@@ -0,0 +1,31 @@
+package ziface
+
+import "time"
+
+// 保存在会话存储中的会话
+type SessionData struct {
+	// 会话所属的用户
+	User string
+	// 会话的连接属性
+	Properties map[string]interface{}
+	// 会话与连接分离的时间，会话仍绑定在连接上时为零值
+	DetachedAt time.Time
+}
+
+// 会话存储，连接属性可以同步到存储中，进程重启后客户端恢复会话时从存储中恢复连接属性
+type ISessionStore interface {
+	// 保存完整的会话，覆盖之前的内容
+	Save(session string, data *SessionData) error
+	// 设置会话的一个属性，会话不存在时忽略
+	SetProperty(session string, key string, value interface{}) error
+	// 移除会话的一个属性
+	RemoveProperty(session string, key string) error
+	// 读取会话，会话不存在时返回 nil
+	Load(session string) (*SessionData, error)
+	// 删除会话
+	Delete(session string) error
+	// 遍历全部会话，fn 返回 false 时停止遍历
+	Range(fn func(session string, data *SessionData) bool) error
+	// 关闭存储
+	Close() error
+}
@@ -0,0 +1,39 @@
+package znet
+
+import (
+	"errors"
+
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+var (
+	// 连接已关闭或正在关闭
+	ErrConnClosed = errors.New("Connection closed when send msg")
+	// 连接的发送队列已满
+	ErrSendQueueFull = errors.New("send queue is full")
+	// 连接管理器中找不到连接
+	ErrConnNotFound = errors.New("connection not found")
+)
+
+// 将消息编码一次，然后以非阻塞的方式放入每个连接的发送队列
+func broadcastPacked(codec ziface.IFrameCodec, conns []ziface.IConnection, msgID uint32, data []byte) []ziface.SendResult {
+	if len(conns) == 0 {
+		return nil
+	}
+
+	results := make([]ziface.SendResult, len(conns))
+	packed, err := packMessage(codec, msgID, data)
+	for i, conn := range conns {
+		results[i].ConnID = conn.GetConnID()
+		switch {
+		case err != nil:
+			results[i].Err = err
+		case connClosing(conn):
+			// 跳过正在关闭的连接
+			results[i].Err = ErrConnClosed
+		default:
+			results[i].Err = conn.SendPackedBuffMsg(msgID, packed)
+		}
+	}
+	return results
+}
@@ -0,0 +1,57 @@
+package znet
+
+import "sync"
+
+// 消息体缓冲池的尺寸分级，按从小到大排列
+// 超过最大分级的消息体直接分配，不进入缓冲池
+var bufSizeClasses = []int{64, 256, 1024, 4096, 16384, 65536}
+
+// 每个尺寸分级对应一个 sync.Pool，池中存放 *[]byte，避免放回时额外分配
+var bufPools = func() []*sync.Pool {
+	pools := make([]*sync.Pool, len(bufSizeClasses))
+	for i, size := range bufSizeClasses {
+		size := size
+		pools[i] = &sync.Pool{
+			New: func() interface{} {
+				buf := make([]byte, size)
+				return &buf
+			},
+		}
+	}
+	return pools
+}()
+
+// 找到能容纳 size 字节的最小分级，找不到返回 -1
+func bufClass(size int) int {
+	for i, classSize := range bufSizeClasses {
+		if size <= classSize {
+			return i
+		}
+	}
+	return -1
+}
+
+// 从缓冲池中取出一块长度为 size 的缓冲
+func getBuff(size uint32) *[]byte {
+	idx := bufClass(int(size))
+	if idx < 0 {
+		buf := make([]byte, size)
+		return &buf
+	}
+	buf := bufPools[idx].Get().(*[]byte)
+	*buf = (*buf)[:size]
+	return buf
+}
+
+// 将缓冲归还给缓冲池，只有容量恰好等于某个分级的缓冲才会被回收
+func putBuff(buf *[]byte) {
+	if buf == nil {
+		return
+	}
+	idx := bufClass(cap(*buf))
+	if idx < 0 || cap(*buf) != bufSizeClasses[idx] {
+		return
+	}
+	*buf = (*buf)[:cap(*buf)]
+	bufPools[idx].Put(buf)
+}
@@ -0,0 +1,53 @@
+package znet
+
+import "testing"
+
+func TestGetBuffSizeClasses(t *testing.T) {
+	cases := []struct {
+		size    uint32
+		wantCap int
+	}{
+		{0, 64},
+		{1, 64},
+		{64, 64},
+		{65, 256},
+		{4096, 4096},
+		{4097, 16384},
+		{65536, 65536},
+		// 超过最大分级时按实际长度分配
+		{65537, 65537},
+		{1 << 20, 1 << 20},
+	}
+	for _, c := range cases {
+		buf := getBuff(c.size)
+		if len(*buf) != int(c.size) || cap(*buf) != c.wantCap {
+			t.Errorf("getBuff(%d) len %d cap %d, want len %d cap %d", c.size, len(*buf), cap(*buf), c.size, c.wantCap)
+		}
+		putBuff(buf)
+	}
+}
+
+func TestPutBuffForeign(t *testing.T) {
+	putBuff(nil)
+
+	// 容量不等于任何分级的缓冲不能进入缓冲池，否则之后取出的缓冲容量不对
+	for i := 0; i < 100; i++ {
+		foreign := make([]byte, 65, 100)
+		putBuff(&foreign)
+		oversized := make([]byte, 65537)
+		putBuff(&oversized)
+		if buf := getBuff(65); cap(*buf) != 256 {
+			t.Fatalf("getBuff(65) cap %d after putBuff of a foreign slice, want 256", cap(*buf))
+		}
+		if buf := getBuff(65536); cap(*buf) != 65536 {
+			t.Fatalf("getBuff(65536) cap %d after putBuff of an oversized slice, want 65536", cap(*buf))
+		}
+	}
+
+	// 归还的缓冲在下次取出时恢复为请求的长度
+	buf := getBuff(10)
+	putBuff(buf)
+	if buf := getBuff(64); len(*buf) != 64 {
+		t.Fatalf("getBuff(64) len %d after reuse, want 64", len(*buf))
+	}
+}
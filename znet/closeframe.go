@@ -0,0 +1,27 @@
+package znet
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+// 构造关闭帧的消息内容: 原因码 uint32(4字节，小端序) + 原因说明
+func packCloseFrameData(reason ziface.CloseReason) []byte {
+	text := reason.Code.String()
+	if reason.Err != nil {
+		text = reason.Err.Error()
+	}
+	data := make([]byte, 0, 4+len(text))
+	data = binary.LittleEndian.AppendUint32(data, uint32(reason.Code))
+	return append(data, text...)
+}
+
+// 解析服务端发送的关闭帧消息内容，得到关闭原因码和原因说明，供客户端使用
+func ParseCloseFrame(data []byte) (ziface.CloseCode, string, error) {
+	if len(data) < 4 {
+		return ziface.CloseNone, "", errors.New("close frame data too short")
+	}
+	return ziface.CloseCode(binary.LittleEndian.Uint32(data[:4])), string(data[4:]), nil
+}
@@ -0,0 +1,69 @@
+package znet
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/dokidokikoi/my-zinx/utils"
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+func TestCloseFrameData(t *testing.T) {
+	data := packCloseFrameData(ziface.CloseReason{Code: ziface.CloseKicked, Err: errors.New("bye")})
+	want := append([]byte{byte(ziface.CloseKicked), 0, 0, 0}, "bye"...)
+	if !bytes.Equal(data, want) {
+		t.Fatalf("close frame data = % x, want % x", data, want)
+	}
+	code, text, err := ParseCloseFrame(data)
+	if err != nil || code != ziface.CloseKicked || text != "bye" {
+		t.Fatalf("ParseCloseFrame = %v %q %v", code, text, err)
+	}
+
+	// 没有错误时使用原因码的说明
+	code, text, _ = ParseCloseFrame(packCloseFrameData(ziface.CloseReason{Code: ziface.CloseServerShutdown}))
+	if code != ziface.CloseServerShutdown || text != ziface.CloseServerShutdown.String() {
+		t.Fatalf("ParseCloseFrame without err = %v %q", code, text)
+	}
+	if _, _, err := ParseCloseFrame([]byte{1, 0}); err == nil {
+		t.Fatal("ParseCloseFrame short data should fail")
+	}
+}
+
+func TestCloseFrameServer(t *testing.T) {
+	setTestConfig(t, func(conf *utils.GlobalObj) {
+		conf.SendCloseFrame = true
+	})
+	s := newTestServer(t)
+	reasons := make(chan ziface.CloseReason, 1)
+	s.SetOnConnStop(func(conn ziface.IConnection) { reasons <- conn.CloseReason() })
+
+	client, conn := dialTestServer(t, s)
+	conn.StopWithReason(ziface.CloseKicked, errors.New("bye"))
+
+	// 关闭帧是连接写出的最后一帧: DataLen + MsgID + 原因码 + 原因说明，之后是 FIN
+	_ = client.SetReadDeadline(time.Now().Add(3 * time.Second))
+	got, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{7, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, byte(ziface.CloseKicked), 0, 0, 0, 'b', 'y', 'e'}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("close frame on the wire = % x, want % x", got, want)
+	}
+	if utils.GlobalObject.CloseFrameMsgID != math.MaxUint32 {
+		t.Fatalf("default close frame msgID = %d", utils.GlobalObject.CloseFrameMsgID)
+	}
+
+	select {
+	case reason := <-reasons:
+		if reason.Code != ziface.CloseKicked || reason.Err == nil || reason.Err.Error() != "bye" {
+			t.Fatalf("OnConnStop reason = %+v", reason)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("OnConnStop not called")
+	}
+}
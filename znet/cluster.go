@@ -0,0 +1,749 @@
+package znet
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dokidokikoi/my-zinx/utils"
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+var (
+	// 集群中没有该节点
+	ErrNodeNotFound = errors.New("cluster node not found")
+	// 与节点的连接未建立或已断开
+	ErrNodeUnavailable = errors.New("cluster node unavailable")
+	// 等待节点返回发送结果超时
+	ErrClusterTimeout = errors.New("cluster request timeout")
+	// 用户不在线
+	ErrUserOffline = errors.New("user offline")
+	// 发送请求编码后超过 MaxPacketSize，对端无法拆包
+	ErrClusterMsgTooLarge = errors.New("cluster message too large")
+)
+
+const (
+	// 通过认证的入站连接上记录对端节点 ID 的属性
+	clusterNodeProperty = "zinx.cluster.node"
+	// 出站连接上记录目标节点 ID 的属性
+	clusterPeerProperty = "zinx.cluster.peer"
+	// 记录本端发出的认证随机数的属性，入站连接和出站连接都会使用
+	clusterNonceProperty = "zinx.cluster.nonce"
+)
+
+// 集群配置
+type clusterConfig struct {
+	nodeID uint32
+	host   string
+	port   int
+	peers  []utils.ClusterPeer
+	secret string
+}
+
+func clusterConfigFromGlobal() *clusterConfig {
+	return &clusterConfig{
+		nodeID: utils.GlobalObject.ClusterNodeID,
+		host:   utils.GlobalObject.ClusterHost,
+		port:   utils.GlobalObject.ClusterPort,
+		peers:  utils.GlobalObject.ClusterPeers,
+		secret: utils.GlobalObject.ClusterSecret,
+	}
+}
+
+// 与一个节点的出站连接，本节点的发送请求和在线状态通过该连接发给对端
+type clusterPeer struct {
+	nodeID uint32
+	addr   string
+	// 双方都通过认证的出站连接，未连通时为 nil
+	conn *Connection
+	// 保护 conn，并保证在线状态按变化的顺序放入发送队列，持有期间不做网络读写
+	lock sync.Mutex
+}
+
+// 等待结果的发送请求
+type clusterCall struct {
+	nodeID uint32
+	done   chan ziface.ClusterResult
+}
+
+// ICluster 的实现
+// 每个节点监听一个总线端口，并主动连接配置中的其他节点，总线连接同样是使用 DataPack 封包的 zinx 连接。
+// 双方使用共享密钥互相认证: 接受连接的一方先发送随机数，发起方用共享密钥计算 HMAC 后连同节点 ID 和自己的随机数返回，
+// 接受方只接受配置中节点的连接，验证通过后同样对发起方的随机数计算 HMAC 返回，发起方验证后才开始使用该连接。
+// 发送请求通过出站连接发给目标节点，目标节点在本地发送后通过同一连接返回结果；
+// 用户绑定和解除绑定时通知其他节点，用于将发送给用户的消息路由到用户所在的节点。
+// 总线消息都以非阻塞的方式放入连接的发送队列，对端读取过慢导致队列已满时断开重连并重新同步在线状态
+type Cluster struct {
+	server ziface.IServer
+	conf   *clusterConfig
+	// 处理总线连接的内部 Server
+	bus      *Server
+	handler  *MsgHandler
+	listener *net.TCPListener
+	peers    map[uint32]*clusterPeer
+
+	// 等待结果的发送请求
+	calls     map[uint64]*clusterCall
+	callsLock sync.Mutex
+	callSeq   atomic.Uint64
+
+	// 其他节点上在线的用户: 节点 -> 用户 -> ConnID
+	presence map[uint32]map[string]map[uint32]struct{}
+	// 节点 -> 当前的入站连接，旧连接关闭时不清理新连接同步的在线状态
+	inbound      map[uint32]uint32
+	presenceLock sync.RWMutex
+
+	done chan struct{}
+	// 接受连接和维持出站连接的 Goroutine
+	loops sync.WaitGroup
+	// 总线连接的 Goroutine
+	conns sync.WaitGroup
+}
+
+func (c *Cluster) NodeID() uint32 {
+	return c.conf.nodeID
+}
+
+func (c *Cluster) Nodes() []uint32 {
+	var nodes []uint32
+	for _, peer := range c.peers {
+		peer.lock.Lock()
+		if peer.conn != nil {
+			nodes = append(nodes, peer.nodeID)
+		}
+		peer.lock.Unlock()
+	}
+	return nodes
+}
+
+func (c *Cluster) SendToConn(nodeID uint32, connID uint32, msgID uint32, data []byte) error {
+	if nodeID == c.conf.nodeID {
+		_, err := c.deliverLocal(routeConn, connID, "", msgID, data)
+		return err
+	}
+	return c.call(nodeID, &routeRequest{kind: routeConn, connID: connID, msgID: msgID, data: data}).Err
+}
+
+func (c *Cluster) SendToUser(user string, msgID uint32, data []byte) error {
+	delivered, err := c.deliverLocal(routeUser, 0, user, msgID, data)
+	if errors.Is(err, ErrUserOffline) {
+		err = nil
+	}
+	nodes := c.userNodes(user)
+	if delivered == 0 && err == nil && len(nodes) == 0 {
+		return ErrUserOffline
+	}
+
+	for _, result := range c.callNodes(nodes, &routeRequest{kind: routeUser, target: user, msgID: msgID, data: data}) {
+		delivered += result.Delivered
+		if err == nil {
+			err = result.Err
+		}
+	}
+	if delivered > 0 {
+		return nil
+	}
+	return err
+}
+
+func (c *Cluster) SendToGroup(group string, msgID uint32, data []byte) []ziface.ClusterResult {
+	delivered, err := c.deliverLocal(routeGroup, 0, group, msgID, data)
+	results := []ziface.ClusterResult{{NodeID: c.conf.nodeID, Delivered: delivered, Err: err}}
+
+	nodes := make([]uint32, 0, len(c.peers))
+	for nodeID := range c.peers {
+		nodes = append(nodes, nodeID)
+	}
+	return append(results, c.callNodes(nodes, &routeRequest{kind: routeGroup, target: group, msgID: msgID, data: data})...)
+}
+
+// 在本节点发送消息，返回成功放入发送队列的连接数
+func (c *Cluster) deliverLocal(kind byte, connID uint32, target string, msgID uint32, data []byte) (int, error) {
+	switch kind {
+	case routeConn:
+		conn, err := c.server.GetConnMgr().Get(connID)
+		if err != nil {
+			return 0, ErrConnNotFound
+		}
+		return countDelivered(broadcastPacked(c.server.Codec(), []ziface.IConnection{conn}, msgID, data))
+	case routeUser:
+		conns := c.server.GetSessionMgr().GetByUser(target)
+		if len(conns) == 0 {
+			return 0, ErrUserOffline
+		}
+		return countDelivered(broadcastPacked(c.server.Codec(), conns, msgID, data))
+	case routeGroup:
+		return countDelivered(c.server.GetGroupMgr().Broadcast(target, msgID, data))
+	}
+	return 0, errBusMsgInvalid
+}
+
+// 统计成功发送的连接数，全部失败时返回其中一个错误
+func countDelivered(results []ziface.SendResult) (int, error) {
+	var delivered int
+	var err error
+	for _, result := range results {
+		if result.Err != nil {
+			err = result.Err
+			continue
+		}
+		delivered++
+	}
+	if delivered > 0 {
+		err = nil
+	}
+	return delivered, err
+}
+
+// 并发地向多个节点发送请求
+func (c *Cluster) callNodes(nodes []uint32, req *routeRequest) []ziface.ClusterResult {
+	results := make([]ziface.ClusterResult, len(nodes))
+	var wg sync.WaitGroup
+	for i, nodeID := range nodes {
+		wg.Add(1)
+		go func(i int, nodeID uint32) {
+			defer wg.Done()
+			r := *req
+			results[i] = c.call(nodeID, &r)
+		}(i, nodeID)
+	}
+	wg.Wait()
+	return results
+}
+
+// 向节点发送请求并等待结果
+func (c *Cluster) call(nodeID uint32, req *routeRequest) ziface.ClusterResult {
+	result := ziface.ClusterResult{NodeID: nodeID}
+	peer, ok := c.peers[nodeID]
+	if !ok {
+		result.Err = ErrNodeNotFound
+		return result
+	}
+	if err := checkRouteSize(req); err != nil {
+		result.Err = err
+		return result
+	}
+	peer.lock.Lock()
+	conn := peer.conn
+	peer.lock.Unlock()
+	if conn == nil {
+		result.Err = ErrNodeUnavailable
+		return result
+	}
+
+	timeout := time.NewTimer(time.Duration(utils.GlobalObject.ClusterTimeout) * time.Millisecond)
+	defer timeout.Stop()
+
+	req.reqID = c.callSeq.Add(1)
+	call := &clusterCall{nodeID: nodeID, done: make(chan ziface.ClusterResult, 1)}
+	c.callsLock.Lock()
+	c.calls[req.reqID] = call
+	c.callsLock.Unlock()
+
+	if err := c.send(conn, busMsgRoute, req.encode()); err != nil {
+		c.removeCall(req.reqID)
+		result.Err = ErrNodeUnavailable
+		return result
+	}
+
+	select {
+	case result = <-call.done:
+		return result
+	case <-timeout.C:
+		c.removeCall(req.reqID)
+		result.Err = ErrClusterTimeout
+		return result
+	}
+}
+
+// 检查发送请求编码后是否超过对端拆包允许的大小，超过时对端会断开总线连接
+func checkRouteSize(req *routeRequest) error {
+	if len(req.target) > math.MaxUint16 {
+		return ErrClusterMsgTooLarge
+	}
+	size := uint64(routeRequestHeadLen + len(req.target) + len(req.data))
+	if maxSize := utils.GlobalObject.MaxPacketSize; maxSize > 0 && size > uint64(maxSize) {
+		return ErrClusterMsgTooLarge
+	}
+	return nil
+}
+
+// 将总线消息以非阻塞的方式放入连接的发送队列，对端读取过慢时不会阻塞调用方
+func (c *Cluster) send(conn *Connection, msgID uint32, data []byte) error {
+	packed, err := conn.packMsg(msgID, data)
+	if err != nil {
+		return err
+	}
+	conn.RLock()
+	defer conn.RUnlock()
+	if conn.isClosed {
+		return ErrConnClosed
+	}
+	return conn.enqueue(packed)
+}
+
+func (c *Cluster) removeCall(reqID uint64) *clusterCall {
+	c.callsLock.Lock()
+	defer c.callsLock.Unlock()
+
+	call, ok := c.calls[reqID]
+	if ok {
+		delete(c.calls, reqID)
+	}
+	return call
+}
+
+// 收到 nodeID 返回的请求结果，请求不是发给该节点时忽略
+func (c *Cluster) finishCall(nodeID uint32, reqID uint64, delivered int, err error) {
+	c.callsLock.Lock()
+	call, ok := c.calls[reqID]
+	if !ok || call.nodeID != nodeID {
+		c.callsLock.Unlock()
+		return
+	}
+	delete(c.calls, reqID)
+	c.callsLock.Unlock()
+
+	call.done <- ziface.ClusterResult{NodeID: nodeID, Delivered: delivered, Err: err}
+}
+
+// 与节点断开后，等待该节点结果的请求全部失败
+func (c *Cluster) failCalls(nodeID uint32) {
+	c.callsLock.Lock()
+	defer c.callsLock.Unlock()
+
+	for reqID, call := range c.calls {
+		if call.nodeID == nodeID {
+			delete(c.calls, reqID)
+			call.done <- ziface.ClusterResult{NodeID: nodeID, Err: ErrNodeUnavailable}
+		}
+	}
+}
+
+// 获取用户所在的其他节点
+func (c *Cluster) userNodes(user string) []uint32 {
+	c.presenceLock.RLock()
+	defer c.presenceLock.RUnlock()
+
+	var nodes []uint32
+	for nodeID, users := range c.presence {
+		if len(users[user]) > 0 {
+			nodes = append(nodes, nodeID)
+		}
+	}
+	return nodes
+}
+
+// 对端节点通过入站连接发来握手，验证通过后返回本节点的认证结果，之后会重新同步该节点的全部在线用户
+func (c *Cluster) peerHello(conn *Connection, data []byte) error {
+	if _, ok := c.inboundNode(conn); ok || len(data) != busHelloLen {
+		return errBusMsgInvalid
+	}
+	nodeID := binary.LittleEndian.Uint32(data)
+	if _, ok := c.peers[nodeID]; !ok {
+		return ErrNodeNotFound
+	}
+	nonce, err := conn.GetProperty(clusterNonceProperty)
+	if err != nil || !hmac.Equal(data[4:4+sha256.Size], helloMAC(c.conf.secret, nonce.([]byte), nodeID)) {
+		return errBusUnauthorized
+	}
+	conn.RemoveProperty(clusterNonceProperty)
+	conn.SetProperty(clusterNodeProperty, nodeID)
+
+	c.presenceLock.Lock()
+	c.inbound[nodeID] = conn.GetConnID()
+	delete(c.presence, nodeID)
+	c.presenceLock.Unlock()
+
+	return c.send(conn, busMsgWelcome, welcomeMAC(c.conf.secret, data[4+sha256.Size:], c.conf.nodeID))
+}
+
+// 获取通过认证的入站连接对应的节点
+func (c *Cluster) inboundNode(conn ziface.IConnection) (uint32, bool) {
+	value, err := conn.GetProperty(clusterNodeProperty)
+	if err != nil {
+		return 0, false
+	}
+	return value.(uint32), true
+}
+
+// 获取双方都通过认证的出站连接对应的节点
+func (c *Cluster) outboundNode(conn ziface.IConnection) (uint32, bool) {
+	peer, ok := c.outboundPeer(conn)
+	if !ok {
+		return 0, false
+	}
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	return peer.nodeID, peer.conn == conn
+}
+
+// 获取出站连接对应的节点，连接可能还没有完成认证
+func (c *Cluster) outboundPeer(conn ziface.IConnection) (*clusterPeer, bool) {
+	value, err := conn.GetProperty(clusterPeerProperty)
+	if err != nil {
+		return nil, false
+	}
+	peer, ok := c.peers[value.(uint32)]
+	return peer, ok
+}
+
+// 更新对端节点的用户在线状态
+func (c *Cluster) updatePresence(conn ziface.IConnection, nodeID uint32, entries []presenceEntry) {
+	c.presenceLock.Lock()
+	defer c.presenceLock.Unlock()
+
+	if c.inbound[nodeID] != conn.GetConnID() {
+		return
+	}
+	users := c.presence[nodeID]
+	for _, entry := range entries {
+		if entry.online {
+			if users == nil {
+				users = make(map[string]map[uint32]struct{})
+				c.presence[nodeID] = users
+			}
+			if users[entry.user] == nil {
+				users[entry.user] = make(map[uint32]struct{})
+			}
+			users[entry.user][entry.connID] = struct{}{}
+			continue
+		}
+		delete(users[entry.user], entry.connID)
+		if len(users[entry.user]) == 0 {
+			delete(users, entry.user)
+		}
+	}
+}
+
+// 入站连接断开时清理对端节点的在线用户
+func (c *Cluster) inboundClosed(conn ziface.IConnection) {
+	nodeID, ok := c.inboundNode(conn)
+	if !ok {
+		return
+	}
+
+	c.presenceLock.Lock()
+	defer c.presenceLock.Unlock()
+
+	if c.inbound[nodeID] == conn.GetConnID() {
+		delete(c.inbound, nodeID)
+		delete(c.presence, nodeID)
+	}
+}
+
+// 本节点的用户绑定到连接，通知其他节点
+func (c *Cluster) sessionBound(user string, conn ziface.IConnection) {
+	c.broadcastPresence(user, conn.GetConnID(), true)
+}
+
+// 本节点的用户与连接解除绑定，通知其他节点
+func (c *Cluster) sessionUnbound(user string, conn ziface.IConnection) {
+	c.broadcastPresence(user, conn.GetConnID(), false)
+}
+
+func (c *Cluster) broadcastPresence(user string, connID uint32, online bool) {
+	if !presenceFits(user) {
+		fmt.Println("cluster presence skipped, user name too long", len(user))
+		return
+	}
+	data := appendPresence(nil, user, connID, online)
+	for _, peer := range c.peers {
+		peer.lock.Lock()
+		if peer.conn != nil {
+			if err := c.send(peer.conn, busMsgPresence, data); err != nil {
+				// 丢失在线状态会导致对端路由错误，断开后重新连接并同步全部在线用户
+				peer.conn.StopWithReason(ziface.CloseSlowConsumer, err)
+				peer.conn = nil
+			}
+		}
+		peer.lock.Unlock()
+	}
+}
+
+// 一帧在线状态的最大长度，不超过对端拆包允许的大小
+func presenceFrameLimit() int {
+	limit := 4096
+	if maxSize := int(utils.GlobalObject.MaxPacketSize); maxSize > 0 && maxSize < limit {
+		limit = maxSize
+	}
+	return limit
+}
+
+// 用户名过长时无法放入一帧在线状态
+func presenceFits(user string) bool {
+	return len(user) <= math.MaxUint16 && 7+len(user) <= presenceFrameLimit()
+}
+
+// 创建总线连接，总线连接不限流，并保证消息按到达顺序处理
+func (c *Cluster) newBusConnection(conn *net.TCPConn, connID uint32) *Connection {
+	busConn := NewBaseConnection(c.bus, conn, connID, c.handler)
+	busConn.limiter = nil
+	if utils.GlobalObject.WorkerPoolSize == 0 && busConn.executor == nil {
+		busConn.executor = newSerialExecutor(c.handler, int(utils.GlobalObject.MaxWorkerTaskLen))
+	}
+	return busConn
+}
+
+// 在单独的 Goroutine 中启动总线连接，关闭集群时等待其退出
+func (c *Cluster) startBusConn(conn *Connection) {
+	c.conns.Add(1)
+	go func() {
+		defer c.conns.Done()
+		conn.Start()
+	}()
+}
+
+// 接受其他节点的连接，发送认证随机数
+func (c *Cluster) accept() {
+	defer c.loops.Done()
+
+	var cid uint32
+	for {
+		conn, err := c.listener.AcceptTCP()
+		if err != nil {
+			select {
+			case <-c.done:
+				return
+			default:
+			}
+			fmt.Println("cluster accept err", err)
+			continue
+		}
+		nonce := make([]byte, busNonceLen)
+		if _, err := rand.Read(nonce); err != nil {
+			fmt.Println("cluster nonce err", err)
+			_ = conn.Close()
+			continue
+		}
+		busConn := c.newBusConnection(conn, cid)
+		cid++
+		busConn.SetProperty(clusterNonceProperty, nonce)
+		c.bus.ConnMgr.Add(busConn)
+		c.startBusConn(busConn)
+		_ = c.send(busConn, busMsgChallenge, nonce)
+
+		c.conns.Add(1)
+		go c.handshakeTimeout(busConn, func() bool {
+			_, ok := c.inboundNode(busConn)
+			return ok
+		})
+	}
+}
+
+// 在 ClusterTimeout 内没有完成握手的总线连接将被断开，authed 返回连接是否已经通过认证
+func (c *Cluster) handshakeTimeout(conn *Connection, authed func() bool) {
+	defer c.conns.Done()
+
+	timer := time.NewTimer(time.Duration(utils.GlobalObject.ClusterTimeout) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		if !authed() {
+			conn.StopWithReason(ziface.CloseUnauthorized, errBusUnauthorized)
+		}
+	case <-conn.Context().Done():
+	}
+}
+
+// 保持与节点的出站连接，断开后按 ClusterRetryInterval 重新连接
+// 建立连接和完成握手都需要在 ClusterTimeout 内完成，否则断开后重试
+// 出站连接不加入总线的连接管理，使用从 MaxUint32 向下分配的 ConnID 避免与入站连接冲突
+func (c *Cluster) dial(peer *clusterPeer, connID uint32) {
+	defer c.loops.Done()
+
+	timeout := time.Duration(utils.GlobalObject.ClusterTimeout) * time.Millisecond
+	retry := time.Duration(utils.GlobalObject.ClusterRetryInterval) * time.Millisecond
+	for {
+		if conn, err := net.DialTimeout("tcp4", peer.addr, timeout); err == nil {
+			busConn := c.newBusConnection(conn.(*net.TCPConn), connID)
+			busConn.SetProperty(clusterPeerProperty, peer.nodeID)
+			c.startBusConn(busConn)
+			c.conns.Add(1)
+			go c.handshakeTimeout(busConn, func() bool {
+				_, ok := c.outboundNode(busConn)
+				return ok
+			})
+
+			select {
+			case <-busConn.Context().Done():
+			case <-c.done:
+				busConn.StopWithReason(ziface.CloseServerShutdown, nil)
+			}
+			peer.lock.Lock()
+			if peer.conn == busConn {
+				peer.conn = nil
+			}
+			peer.lock.Unlock()
+			c.failCalls(peer.nodeID)
+		}
+
+		select {
+		case <-c.done:
+			return
+		case <-time.After(retry):
+		}
+	}
+}
+
+// 收到对端的认证随机数后发送握手，同时发送本端的随机数要求对端证明持有密钥
+func (c *Cluster) sendHello(conn *Connection, nonce []byte) error {
+	// 重复收到随机数
+	if _, err := conn.GetProperty(clusterNonceProperty); err == nil {
+		return errBusMsgInvalid
+	}
+	own := make([]byte, busNonceLen)
+	if _, err := rand.Read(own); err != nil {
+		return err
+	}
+	conn.SetProperty(clusterNonceProperty, own)
+
+	hello := binary.LittleEndian.AppendUint32(nil, c.conf.nodeID)
+	hello = append(hello, helloMAC(c.conf.secret, nonce, c.conf.nodeID)...)
+	return c.send(conn, busMsgHello, append(hello, own...))
+}
+
+// 对端证明持有密钥后开始使用出站连接，并同步本节点的全部在线用户
+// 持有 peer.lock 生成快照并放入发送队列，保证之后的在线状态变化排在快照之后
+func (c *Cluster) connected(peer *clusterPeer, conn *Connection, mac []byte) error {
+	nonce, err := conn.GetProperty(clusterNonceProperty)
+	if err != nil || !hmac.Equal(mac, welcomeMAC(c.conf.secret, nonce.([]byte), peer.nodeID)) {
+		conn.StopWithReason(ziface.CloseUnauthorized, errBusUnauthorized)
+		return errBusUnauthorized
+	}
+	conn.RemoveProperty(clusterNonceProperty)
+
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+
+	// 连接已经断开，或已有其他出站连接
+	if conn.Context().Err() != nil || peer.conn != nil {
+		conn.StopWithReason(ziface.CloseUnauthorized, errBusMsgInvalid)
+		return errBusMsgInvalid
+	}
+	if err := c.sendPresenceSnapshot(conn); err != nil {
+		conn.StopWithReason(ziface.CloseSlowConsumer, err)
+		return err
+	}
+	peer.conn = conn
+	return nil
+}
+
+// 将本节点的全部在线用户分批放入发送队列
+func (c *Cluster) sendPresenceSnapshot(conn *Connection) error {
+	limit := presenceFrameLimit()
+	sessionMgr := c.server.GetSessionMgr()
+	var batch []byte
+	var err error
+	c.server.GetConnMgr().Range(func(local ziface.IConnection) bool {
+		user, ok := sessionMgr.UserOf(local)
+		if !ok || !presenceFits(user) {
+			return true
+		}
+		if len(batch)+7+len(user) > limit {
+			if err = c.send(conn, busMsgPresence, batch); err != nil {
+				return false
+			}
+			batch = nil
+		}
+		batch = appendPresence(batch, user, local.GetConnID(), true)
+		return true
+	})
+	if err == nil && len(batch) > 0 {
+		err = c.send(conn, busMsgPresence, batch)
+	}
+	return err
+}
+
+// 开始监听总线端口并连接其他节点
+func (c *Cluster) Start() error {
+	// 没有预先设置监听时按配置监听总线端口
+	if c.listener == nil {
+		addr, err := net.ResolveTCPAddr("tcp4", fmt.Sprintf("%s:%d", c.conf.host, c.conf.port))
+		if err != nil {
+			return err
+		}
+		if c.listener, err = net.ListenTCP("tcp4", addr); err != nil {
+			return err
+		}
+	}
+	if c.conf.secret == "" {
+		fmt.Println("[WARN] cluster secret is empty, any client reaching", c.listener.Addr(), "can join the cluster bus")
+	}
+	c.handler.StartWorkerPool()
+	c.loops.Add(1)
+	go c.accept()
+
+	var i uint32
+	for _, peer := range c.peers {
+		c.loops.Add(1)
+		go c.dial(peer, math.MaxUint32-i)
+		i++
+	}
+	return nil
+}
+
+// 关闭总线端口和全部总线连接，等待总线的 Goroutine 全部退出
+func (c *Cluster) Stop() {
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+	close(c.done)
+	if c.listener != nil {
+		_ = c.listener.Close()
+	}
+	// 接受连接的 Goroutine 退出后不会再有新的入站连接
+	c.loops.Wait()
+	c.bus.ConnMgr.ClearConn()
+	c.conns.Wait()
+	c.handler.StopWorkerPool()
+}
+
+func newCluster(server ziface.IServer, conf *clusterConfig) *Cluster {
+	c := &Cluster{
+		server:   server,
+		conf:     conf,
+		peers:    make(map[uint32]*clusterPeer),
+		calls:    make(map[uint64]*clusterCall),
+		presence: make(map[uint32]map[string]map[uint32]struct{}),
+		inbound:  make(map[uint32]uint32),
+		done:     make(chan struct{}),
+	}
+	for _, peer := range conf.peers {
+		if peer.NodeID != conf.nodeID {
+			c.peers[peer.NodeID] = &clusterPeer{nodeID: peer.NodeID, addr: peer.Addr}
+		}
+	}
+
+	c.handler = NewMsgHandler()
+	c.bus = &Server{
+		Name:       "zinx cluster bus",
+		IPVersion:  "tcp4",
+		IP:         conf.host,
+		Port:       conf.port,
+		msgHandler: c.handler,
+		ConnMgr:    NewConnManager(),
+		GroupMgr:   NewGroupManager(),
+		SessionMgr: NewSessionManager(),
+		codec:      NewPacketCodec(NewDataPack()),
+		evictions:  newEvictionTracker(),
+	}
+	c.bus.SetOnConnStop(c.inboundClosed)
+	c.bus.AddRouter(busMsgHello, &busHelloRouter{cluster: c})
+	c.bus.AddRouter(busMsgRoute, &busRouteRouter{cluster: c})
+	c.bus.AddRouter(busMsgResult, &busResultRouter{cluster: c})
+	c.bus.AddRouter(busMsgPresence, &busPresenceRouter{cluster: c})
+	c.bus.AddRouter(busMsgChallenge, &busChallengeRouter{cluster: c})
+	c.bus.AddRouter(busMsgWelcome, &busWelcomeRouter{cluster: c})
+	return c
+}
@@ -0,0 +1,368 @@
+package znet
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dokidokikoi/my-zinx/utils"
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+// 创建一个带发送队列的连接，发送的消息可以从 msgBuffChan 中读取
+func newTestSendConnection(s ziface.IServer, connID uint32) *Connection {
+	c := newTestConnection(connID)
+	c.TcpServer = s
+	c.msgBuffChan = make(chan []byte, 16)
+	s.GetConnMgr().Add(c)
+	return c
+}
+
+// 从连接的发送队列中读取一条消息
+func recvTestMsg(t *testing.T, c *Connection) (uint32, string) {
+	t.Helper()
+	select {
+	case packed := <-c.msgBuffChan:
+		dp := NewDataPack()
+		msg, err := dp.Unpack(packed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg.GetMsgID(), string(packed[dp.GetHeadLen():])
+	case <-time.After(time.Second):
+		t.Fatalf("conn %d received nothing", c.ConnID)
+		return 0, ""
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+const testClusterSecret = "zinx-test-secret"
+
+// 在随机端口上监听总线，测试结束时关闭
+func listenTestBus(t *testing.T) *net.TCPListener {
+	t.Helper()
+	ln, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	return ln
+}
+
+// 使用预先监听的 ln 启动节点，测试结束时关闭
+func startTestNode(t *testing.T, ln *net.TCPListener, nodeID uint32, peers []utils.ClusterPeer) ziface.IServer {
+	t.Helper()
+	node := NewServer(WithCluster(nodeID, "127.0.0.1", ln.Addr().(*net.TCPAddr).Port, peers, testClusterSecret))
+	cluster := node.GetCluster().(*Cluster)
+	cluster.listener = ln
+	if err := cluster.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cluster.Stop)
+	return node
+}
+
+// 在随机端口上启动 n 个节点，测试结束时关闭
+func startTestCluster(t *testing.T, n int) []ziface.IServer {
+	t.Helper()
+	lns := make([]*net.TCPListener, n)
+	peers := make([]utils.ClusterPeer, n)
+	for i := range peers {
+		lns[i] = listenTestBus(t)
+		peers[i] = utils.ClusterPeer{NodeID: uint32(i + 1), Addr: lns[i].Addr().String()}
+	}
+	nodes := make([]ziface.IServer, n)
+	for i, peer := range peers {
+		nodes[i] = startTestNode(t, lns[i], peer.NodeID, peers)
+	}
+	return nodes
+}
+
+func TestCluster(t *testing.T) {
+	nodes := startTestCluster(t, 3)
+	for i, node := range nodes {
+		waitFor(t, fmt.Sprintf("node %d links", i+1), func() bool { return len(node.GetCluster().Nodes()) == 2 })
+	}
+
+	// alice 在节点 2，bob 在节点 3，两人都在分组 room 中
+	alice := newTestSendConnection(nodes[1], 5)
+	bob := newTestSendConnection(nodes[2], 5)
+	if err := nodes[1].GetSessionMgr().Bind("alice", alice); err != nil {
+		t.Fatal(err)
+	}
+	_ = nodes[2].GetSessionMgr().Bind("bob", bob)
+	_ = nodes[1].GetGroupMgr().Join("room", alice)
+	_ = nodes[2].GetGroupMgr().Join("room", bob)
+	cluster := nodes[0].GetCluster()
+	waitFor(t, "presence", func() bool { return len(cluster.(*Cluster).userNodes("bob")) == 1 })
+
+	if err := cluster.SendToConn(2, 5, 10, []byte("to conn")); err != nil {
+		t.Fatal(err)
+	}
+	if id, data := recvTestMsg(t, alice); id != 10 || data != "to conn" {
+		t.Fatalf("alice got %d %q", id, data)
+	}
+	if err := cluster.SendToConn(2, 99, 10, nil); err != ErrConnNotFound {
+		t.Fatalf("SendToConn missing conn err = %v, want ErrConnNotFound", err)
+	}
+	if err := cluster.SendToConn(9, 5, 10, nil); err != ErrNodeNotFound {
+		t.Fatalf("SendToConn missing node err = %v, want ErrNodeNotFound", err)
+	}
+	// 超过对端拆包大小的请求在发送前被拒绝，不会断开总线连接
+	if err := cluster.SendToConn(2, 5, 10, make([]byte, utils.GlobalObject.MaxPacketSize)); err != ErrClusterMsgTooLarge {
+		t.Fatalf("SendToConn oversize err = %v, want ErrClusterMsgTooLarge", err)
+	}
+
+	// 对端的发送错误跨节点后仍然可以用 errors.Is 判断
+	for i := 0; i < cap(alice.msgBuffChan); i++ {
+		alice.msgBuffChan <- nil
+	}
+	if err := cluster.SendToConn(2, 5, 10, nil); !errors.Is(err, ErrSendQueueFull) {
+		t.Fatalf("SendToConn full queue err = %v, want ErrSendQueueFull", err)
+	}
+	for len(alice.msgBuffChan) > 0 {
+		<-alice.msgBuffChan
+	}
+	closing := newTestSendConnection(nodes[1], 6)
+	closing.cancel()
+	if err := cluster.SendToConn(2, 6, 10, nil); !errors.Is(err, ErrConnClosed) {
+		t.Fatalf("SendToConn closing conn err = %v, want ErrConnClosed", err)
+	}
+
+	if err := cluster.SendToUser("bob", 11, []byte("to user")); err != nil {
+		t.Fatal(err)
+	}
+	if id, data := recvTestMsg(t, bob); id != 11 || data != "to user" {
+		t.Fatalf("bob got %d %q", id, data)
+	}
+	if err := cluster.SendToUser("carol", 11, nil); err != ErrUserOffline {
+		t.Fatalf("SendToUser offline err = %v, want ErrUserOffline", err)
+	}
+
+	var delivered int
+	for _, result := range cluster.SendToGroup("room", 12, []byte("to group")) {
+		if result.Err != nil {
+			t.Fatalf("node %d: %v", result.NodeID, result.Err)
+		}
+		delivered += result.Delivered
+	}
+	if delivered != 2 {
+		t.Fatalf("group delivered = %d, want 2", delivered)
+	}
+	recvTestMsg(t, alice)
+	recvTestMsg(t, bob)
+
+	// 用户下线后其他节点不再路由到该节点
+	nodes[2].GetSessionMgr().Unbind(bob)
+	waitFor(t, "offline presence", func() bool { return len(cluster.(*Cluster).userNodes("bob")) == 0 })
+	if err := cluster.SendToUser("bob", 11, nil); err != ErrUserOffline {
+		t.Fatalf("SendToUser after unbind err = %v, want ErrUserOffline", err)
+	}
+
+	// 节点 3 停止后发送失败
+	nodes[2].GetCluster().(*Cluster).Stop()
+	waitFor(t, "node 3 down", func() bool { return len(cluster.Nodes()) == 1 })
+	if err := cluster.SendToConn(3, 5, 10, nil); err != ErrNodeUnavailable {
+		t.Fatalf("SendToConn stopped node err = %v, want ErrNodeUnavailable", err)
+	}
+}
+
+// 模拟其他节点连接总线端口，读取认证随机数
+func dialTestBus(t *testing.T, addr string) (net.Conn, *bufio.Reader, []byte) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	r := bufio.NewReader(conn)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	msg, err := NewPacketCodec(NewDataPack()).Decode(r)
+	if err != nil || msg.GetMsgID() != busMsgChallenge {
+		t.Fatalf("read challenge = %v, %v", msg, err)
+	}
+	return conn, r, append([]byte(nil), msg.GetData()...)
+}
+
+func writeTestBusMsg(t *testing.T, conn net.Conn, msgID uint32, data []byte) {
+	t.Helper()
+	packed, _ := packMessage(NewPacketCodec(NewDataPack()), msgID, data)
+	if _, err := conn.Write(packed); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 等待对端关闭总线连接
+func expectBusClosed(t *testing.T, conn net.Conn, r *bufio.Reader) {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := r.ReadByte(); err == nil || strings.Contains(err.Error(), "timeout") {
+		t.Fatalf("bus connection not closed: %v", err)
+	}
+}
+
+func TestClusterAuth(t *testing.T) {
+	nodes := startTestCluster(t, 2)
+	cluster := nodes[0].GetCluster().(*Cluster)
+	addr := cluster.listener.Addr().String()
+	waitFor(t, "node links", func() bool { return len(cluster.Nodes()) == 1 })
+	user := newTestSendConnection(nodes[1], 5)
+	_ = nodes[1].GetSessionMgr().Bind("alice", user)
+	waitFor(t, "presence", func() bool { return len(cluster.userNodes("alice")) == 1 })
+
+	hello := func(nodeID uint32, secret string, nonce []byte) []byte {
+		data := binary.LittleEndian.AppendUint32(nil, nodeID)
+		data = append(data, helloMAC(secret, nonce, nodeID)...)
+		return append(data, make([]byte, busNonceLen)...)
+	}
+
+	// 密钥错误
+	conn, r, nonce := dialTestBus(t, addr)
+	writeTestBusMsg(t, conn, busMsgHello, hello(2, "wrong", nonce))
+	expectBusClosed(t, conn, r)
+
+	// 不在配置中的节点
+	conn, r, nonce = dialTestBus(t, addr)
+	writeTestBusMsg(t, conn, busMsgHello, hello(9, testClusterSecret, nonce))
+	expectBusClosed(t, conn, r)
+
+	// 未握手直接发送请求
+	conn, r, _ = dialTestBus(t, addr)
+	req := &routeRequest{kind: routeUser, target: "alice", msgID: 1}
+	writeTestBusMsg(t, conn, busMsgRoute, req.encode())
+	expectBusClosed(t, conn, r)
+
+	// 冒充的连接不影响已有节点的在线状态
+	if len(cluster.userNodes("alice")) != 1 || len(cluster.Nodes()) != 1 {
+		t.Fatal("rejected hello changed cluster state")
+	}
+	select {
+	case <-user.msgBuffChan:
+		t.Fatal("unauthenticated route request delivered")
+	default:
+	}
+}
+
+// 模拟节点 2 接受节点 1 的连接，发送随机数并读取 hello，welcome 返回发给节点 1 的应答
+// 返回节点 1 的服务器和与节点 1 之间的连接
+func startFakePeer(t *testing.T, welcome func(hello []byte) []byte) (ziface.IServer, chan net.Conn) {
+	t.Helper()
+	ln, fake := listenTestBus(t), listenTestBus(t)
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := fake.Accept()
+		if err != nil {
+			return
+		}
+		codec := NewPacketCodec(NewDataPack())
+		packed, _ := packMessage(codec, busMsgChallenge, make([]byte, busNonceLen))
+		_, _ = conn.Write(packed)
+		if msg, err := codec.Decode(bufio.NewReader(conn)); err == nil && msg.GetMsgID() == busMsgHello {
+			if reply := welcome(msg.GetData()); reply != nil {
+				packed, _ = packMessage(codec, busMsgWelcome, reply)
+				_, _ = conn.Write(packed)
+			}
+		}
+		accepted <- conn
+	}()
+
+	peers := []utils.ClusterPeer{{NodeID: 1, Addr: ln.Addr().String()}, {NodeID: 2, Addr: fake.Addr().String()}}
+	return startTestNode(t, ln, 1, peers), accepted
+}
+
+func TestClusterAuthPeer(t *testing.T) {
+	setTestConfig(t, func(conf *utils.GlobalObj) {
+		conf.ClusterTimeout = 300
+	})
+	cases := map[string]func(hello []byte) []byte{
+		// 不知道密钥的节点原样返回 hello 中的 MAC
+		"reflected": func(hello []byte) []byte { return hello[4 : 4+sha256.Size] },
+		"wrong secret": func(hello []byte) []byte {
+			return welcomeMAC("wrong", hello[4+sha256.Size:], 2)
+		},
+		// 不返回应答时握手超时
+		"silent": func([]byte) []byte { return nil },
+	}
+	for name, welcome := range cases {
+		t.Run(name, func(t *testing.T) {
+			node, accepted := startFakePeer(t, welcome)
+			user := newTestSendConnection(node, 5)
+			_ = node.GetSessionMgr().Bind("alice", user)
+
+			// 冒充的节点收不到在线状态，连接被断开
+			conn := <-accepted
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			if msg, err := NewPacketCodec(NewDataPack()).Decode(r); err == nil {
+				t.Fatalf("impostor received msg %d", msg.GetMsgID())
+			} else if strings.Contains(err.Error(), "timeout") {
+				t.Fatal("bus connection to impostor not closed")
+			}
+			if len(node.GetCluster().Nodes()) != 0 {
+				t.Fatal("impostor linked as a cluster node")
+			}
+		})
+	}
+}
+
+func TestClusterFinishCall(t *testing.T) {
+	node := NewServer(WithCluster(1, "127.0.0.1", 0, []utils.ClusterPeer{{NodeID: 2}, {NodeID: 3}}, testClusterSecret))
+	cluster := node.GetCluster().(*Cluster)
+	call := &clusterCall{nodeID: 2, done: make(chan ziface.ClusterResult, 1)}
+	cluster.calls[7] = call
+
+	// 其他节点返回的结果被忽略
+	cluster.finishCall(3, 7, 1, nil)
+	if len(call.done) != 0 || cluster.calls[7] != call {
+		t.Fatal("call finished by another node")
+	}
+	cluster.finishCall(2, 7, 1, nil)
+	if result := <-call.done; result.NodeID != 2 || result.Delivered != 1 || result.Err != nil {
+		t.Fatalf("result = %+v", result)
+	}
+}
+
+func TestClusterStalledPeer(t *testing.T) {
+	// 节点 2 完成握手后不再读取数据
+	node, accepted := startFakePeer(t, func(hello []byte) []byte {
+		return welcomeMAC(testClusterSecret, hello[4+sha256.Size:], 2)
+	})
+	cluster := node.GetCluster().(*Cluster)
+	waitFor(t, "stalled peer link", func() bool { return len(cluster.Nodes()) == 1 })
+	defer (<-accepted).Close()
+
+	// 对端的 TCP 窗口写满后，登录和下线不会被阻塞
+	conn := newTestSendConnection(node, 5)
+	name := strings.Repeat("u", 2000)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20000; i++ {
+			_ = node.GetSessionMgr().Bind(fmt.Sprintf("%s%d", name, i), conn)
+		}
+		cluster.Nodes()
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Bind blocked by a stalled cluster peer")
+	}
+	waitFor(t, "stalled link reset", func() bool { return len(cluster.Nodes()) == 0 })
+}
@@ -0,0 +1,317 @@
+package znet
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+// 集群总线上使用的 MsgID，总线使用独立的消息管理，不与业务 MsgID 冲突
+const (
+	// 发起方收到随机数后发送自己的节点 ID: NodeID uint32 + HMAC-SHA256(密钥, 随机数 + NodeID) + 发起方的随机数 [16]byte
+	busMsgHello uint32 = iota + 1
+	// 发送请求: 请求 ID uint64 + 目标类型 byte + ConnID uint32 + MsgID uint32 + 目标名长度 uint16 + 目标名 + 消息体
+	busMsgRoute
+	// 发送结果: 请求 ID uint64 + 状态 byte + 成功发送的连接数 uint32 + 错误说明
+	busMsgResult
+	// 用户在线状态变化，一帧包含多条: (是否在线 byte + ConnID uint32 + 用户名长度 uint16 + 用户)*
+	busMsgPresence
+	// 接受连接后发给发起方的认证随机数: 随机数 [16]byte
+	busMsgChallenge
+	// 接受方验证 hello 后证明自己同样持有密钥: HMAC-SHA256(密钥, "welcome" + 发起方的随机数 + 接受方 NodeID)
+	busMsgWelcome
+)
+
+// 认证随机数的长度
+const busNonceLen = 16
+
+// hello 消息的长度
+const busHelloLen = 4 + sha256.Size + busNonceLen
+
+// 发送请求头部的长度
+const routeRequestHeadLen = 19
+
+// 发送请求的目标类型
+const (
+	routeConn byte = iota
+	routeUser
+	routeGroup
+)
+
+// 发送结果的状态
+const (
+	routeOK byte = iota
+	routeConnNotFound
+	routeUserOffline
+	routeSendFailed
+	routeQueueFull
+	routeConnClosed
+)
+
+// 发送结果中保留原始错误的状态
+var routeStatusErrs = map[byte]error{
+	routeConnNotFound: ErrConnNotFound,
+	routeUserOffline:  ErrUserOffline,
+	routeQueueFull:    ErrSendQueueFull,
+	routeConnClosed:   ErrConnClosed,
+}
+
+var (
+	// 总线消息格式错误
+	errBusMsgInvalid = errors.New("invalid cluster bus message")
+	// 总线连接未通过认证
+	errBusUnauthorized = errors.New("cluster bus connection unauthorized")
+)
+
+// 跨节点的发送请求
+type routeRequest struct {
+	reqID  uint64
+	kind   byte
+	connID uint32
+	msgID  uint32
+	// 用户或分组名
+	target string
+	data   []byte
+}
+
+func (r *routeRequest) encode() []byte {
+	buf := make([]byte, 0, routeRequestHeadLen+len(r.target)+len(r.data))
+	buf = binary.LittleEndian.AppendUint64(buf, r.reqID)
+	buf = append(buf, r.kind)
+	buf = binary.LittleEndian.AppendUint32(buf, r.connID)
+	buf = binary.LittleEndian.AppendUint32(buf, r.msgID)
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(r.target)))
+	buf = append(buf, r.target...)
+	return append(buf, r.data...)
+}
+
+func decodeRouteRequest(buf []byte) (*routeRequest, error) {
+	if len(buf) < routeRequestHeadLen {
+		return nil, errBusMsgInvalid
+	}
+	r := &routeRequest{
+		reqID:  binary.LittleEndian.Uint64(buf[0:8]),
+		kind:   buf[8],
+		connID: binary.LittleEndian.Uint32(buf[9:13]),
+		msgID:  binary.LittleEndian.Uint32(buf[13:17]),
+	}
+	targetLen := int(binary.LittleEndian.Uint16(buf[17:19]))
+	if len(buf) < routeRequestHeadLen+targetLen {
+		return nil, errBusMsgInvalid
+	}
+	r.target = string(buf[routeRequestHeadLen : routeRequestHeadLen+targetLen])
+	r.data = buf[routeRequestHeadLen+targetLen:]
+	return r, nil
+}
+
+// 将发送结果编码为总线消息
+func encodeRouteResult(reqID uint64, delivered int, err error) []byte {
+	status := routeOK
+	if err != nil {
+		status = routeSendFailed
+		for s, statusErr := range routeStatusErrs {
+			if errors.Is(err, statusErr) {
+				status = s
+				break
+			}
+		}
+	}
+
+	buf := make([]byte, 0, 13)
+	buf = binary.LittleEndian.AppendUint64(buf, reqID)
+	buf = append(buf, status)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(delivered))
+	if status == routeSendFailed {
+		buf = append(buf, err.Error()...)
+	}
+	return buf
+}
+
+func decodeRouteResult(buf []byte) (uint64, int, error) {
+	if len(buf) < 13 {
+		return 0, 0, errBusMsgInvalid
+	}
+	reqID := binary.LittleEndian.Uint64(buf[0:8])
+	delivered := int(binary.LittleEndian.Uint32(buf[9:13]))
+	if buf[8] == routeOK {
+		return reqID, delivered, nil
+	}
+	if err, ok := routeStatusErrs[buf[8]]; ok {
+		return reqID, delivered, err
+	}
+	return reqID, delivered, errors.New(string(buf[13:]))
+}
+
+// 用户在线状态的变化
+type presenceEntry struct {
+	user   string
+	connID uint32
+	online bool
+}
+
+// 将在线状态追加到 buf 中
+func appendPresence(buf []byte, user string, connID uint32, online bool) []byte {
+	if online {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+	buf = binary.LittleEndian.AppendUint32(buf, connID)
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(user)))
+	return append(buf, user...)
+}
+
+func decodePresence(buf []byte) ([]presenceEntry, error) {
+	var entries []presenceEntry
+	for len(buf) > 0 {
+		if len(buf) < 7 {
+			return nil, errBusMsgInvalid
+		}
+		userLen := int(binary.LittleEndian.Uint16(buf[5:7]))
+		if len(buf) < 7+userLen {
+			return nil, errBusMsgInvalid
+		}
+		entries = append(entries, presenceEntry{
+			user:   string(buf[7 : 7+userLen]),
+			connID: binary.LittleEndian.Uint32(buf[1:5]),
+			online: buf[0] == 1,
+		})
+		buf = buf[7+userLen:]
+	}
+	return entries, nil
+}
+
+// 握手中发起方证明持有共享密钥
+func helloMAC(secret string, nonce []byte, nodeID uint32) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(nonce)
+	mac.Write(binary.LittleEndian.AppendUint32(nil, nodeID))
+	return mac.Sum(nil)
+}
+
+// 握手中接受方证明持有共享密钥，加上前缀与 helloMAC 区分，对端不能把收到的 hello 原样返回
+func welcomeMAC(secret string, nonce []byte, nodeID uint32) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("welcome"))
+	mac.Write(nonce)
+	mac.Write(binary.LittleEndian.AppendUint32(nil, nodeID))
+	return mac.Sum(nil)
+}
+
+// 处理接受连接的节点发来的认证随机数
+type busChallengeRouter struct {
+	BaseRouter
+	cluster *Cluster
+}
+
+func (r *busChallengeRouter) Handle(request ziface.IRequest) {
+	conn := request.GetConnection().(*Connection)
+	if _, ok := r.cluster.outboundPeer(conn); !ok || len(request.GetData()) != busNonceLen {
+		conn.StopWithReason(ziface.CloseUnauthorized, errBusUnauthorized)
+		return
+	}
+	if err := r.cluster.sendHello(conn, request.GetData()); err != nil {
+		conn.StopWithReason(ziface.CloseUnauthorized, err)
+	}
+}
+
+// 处理接受连接的节点验证 hello 后的应答，验证通过后出站连接才可以使用
+type busWelcomeRouter struct {
+	BaseRouter
+	cluster *Cluster
+}
+
+func (r *busWelcomeRouter) Handle(request ziface.IRequest) {
+	conn := request.GetConnection().(*Connection)
+	peer, ok := r.cluster.outboundPeer(conn)
+	if !ok {
+		conn.StopWithReason(ziface.CloseUnauthorized, errBusUnauthorized)
+		return
+	}
+	if err := r.cluster.connected(peer, conn, request.GetData()); err != nil {
+		fmt.Println("cluster welcome from", conn.RemoteAddr(), "rejected:", err)
+	}
+}
+
+// 处理其他节点建立连接后的握手
+type busHelloRouter struct {
+	BaseRouter
+	cluster *Cluster
+}
+
+func (r *busHelloRouter) Handle(request ziface.IRequest) {
+	conn := request.GetConnection().(*Connection)
+	if err := r.cluster.peerHello(conn, request.GetData()); err != nil {
+		fmt.Println("cluster hello from", conn.RemoteAddr(), "rejected:", err)
+		conn.StopWithReason(ziface.CloseUnauthorized, err)
+	}
+}
+
+// 处理其他节点的发送请求，在本节点发送后返回结果
+type busRouteRouter struct {
+	BaseRouter
+	cluster *Cluster
+}
+
+func (r *busRouteRouter) Handle(request ziface.IRequest) {
+	conn := request.GetConnection().(*Connection)
+	if _, ok := r.cluster.inboundNode(conn); !ok {
+		conn.StopWithReason(ziface.CloseUnauthorized, errBusUnauthorized)
+		return
+	}
+	req, err := decodeRouteRequest(request.GetData())
+	if err != nil {
+		fmt.Println("decode cluster route request err", err)
+		return
+	}
+	delivered, err := r.cluster.deliverLocal(req.kind, req.connID, req.target, req.msgID, req.data)
+	if err := r.cluster.send(conn, busMsgResult, encodeRouteResult(req.reqID, delivered, err)); err != nil {
+		fmt.Println("send cluster route result err", err)
+	}
+}
+
+// 处理发送请求的结果
+type busResultRouter struct {
+	BaseRouter
+	cluster *Cluster
+}
+
+func (r *busResultRouter) Handle(request ziface.IRequest) {
+	conn := request.GetConnection().(*Connection)
+	nodeID, ok := r.cluster.outboundNode(conn)
+	if !ok {
+		conn.StopWithReason(ziface.CloseUnauthorized, errBusUnauthorized)
+		return
+	}
+	reqID, delivered, err := decodeRouteResult(request.GetData())
+	if err == errBusMsgInvalid {
+		fmt.Println("decode cluster route result err", err)
+		return
+	}
+	r.cluster.finishCall(nodeID, reqID, delivered, err)
+}
+
+// 处理其他节点用户在线状态的变化
+type busPresenceRouter struct {
+	BaseRouter
+	cluster *Cluster
+}
+
+func (r *busPresenceRouter) Handle(request ziface.IRequest) {
+	conn := request.GetConnection().(*Connection)
+	nodeID, ok := r.cluster.inboundNode(conn)
+	if !ok {
+		conn.StopWithReason(ziface.CloseUnauthorized, errBusUnauthorized)
+		return
+	}
+	entries, err := decodePresence(request.GetData())
+	if err != nil {
+		fmt.Println("decode cluster presence err", err)
+		return
+	}
+	r.cluster.updatePresence(conn, nodeID, entries)
+}
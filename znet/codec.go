@@ -0,0 +1,173 @@
+package znet
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+// 编解码器不是 PacketCodec 时，Server.Packet 返回的 IPacket 无法按固定长度的头部拆包
+var ErrPacketUnpackUnsupported = errors.New("codec does not support unpacking by a fixed-length head")
+
+// 消息体使用池化缓冲的消息，请求处理完成后归还缓冲
+type pooledMessage struct {
+	ziface.IMessage
+	buf *[]byte
+}
+
+// 无法承载二进制控制帧的编解码器实现该接口，如 TextCodec，
+// 使用这类编解码器时不发送关闭帧，也不开启会话恢复
+type controlFrameless interface {
+	noControlFrames()
+}
+
+func supportsControlFrames(codec ziface.IFrameCodec) bool {
+	_, ok := codec.(controlFrameless)
+	return !ok
+}
+
+// 拆包后需要将部分头部交给路由的 IPacket 实现该接口，
+// PacketCodec 将 keptHead 返回的字节放在消息体前面
+type headKeeper interface {
+	keptHead(head []byte) []byte
+}
+
+// 将 IPacket 适配为 IFrameCodec，先读取固定长度的头部，再按 DataLen 读取消息体
+type PacketCodec struct {
+	packet ziface.IPacket
+	// 内置的 Packet 拆包时不保留头部，头部缓冲可以放回缓冲池；
+	// 自定义的 Packet 返回的消息可能引用头部切片，每次拆包使用新分配的头部
+	poolHead bool
+}
+
+func (pc *PacketCodec) Decode(r *bufio.Reader) (ziface.IMessage, error) {
+	var head *[]byte
+	if pc.poolHead {
+		head = getBuff(pc.packet.GetHeadLen())
+		defer putBuff(head)
+	} else {
+		buf := make([]byte, pc.packet.GetHeadLen())
+		head = &buf
+	}
+	if _, err := io.ReadFull(r, *head); err != nil {
+		return nil, err
+	}
+	msg, err := pc.packet.Unpack(*head)
+	if err != nil {
+		return nil, err
+	}
+
+	var kept []byte
+	if hk, ok := pc.packet.(headKeeper); ok {
+		kept = hk.keptHead(*head)
+	}
+
+	// 根据 dataLen 从缓冲池取出缓冲读取数据
+	if msg.GetDataLen() == 0 && len(kept) == 0 {
+		msg.SetData(nil)
+		return msg, nil
+	}
+	buf := getBuff(uint32(len(kept)) + msg.GetDataLen())
+	copy(*buf, kept)
+	if _, err := io.ReadFull(r, (*buf)[len(kept):]); err != nil {
+		putBuff(buf)
+		return nil, err
+	}
+	msg.SetData(*buf)
+	msg.SetDataLen(uint32(len(*buf)))
+	return &pooledMessage{IMessage: msg, buf: buf}, nil
+}
+
+func (pc *PacketCodec) Encode(w io.Writer, msg ziface.IMessage) error {
+	data, err := pc.AppendEncode(nil, msg)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (pc *PacketCodec) AppendEncode(dst []byte, msg ziface.IMessage) ([]byte, error) {
+	if ap, ok := pc.packet.(ziface.IAppendPacket); ok {
+		return ap.AppendPack(dst, msg)
+	}
+	data, err := pc.packet.Pack(msg)
+	if err != nil {
+		return nil, err
+	}
+	return append(dst, data...), nil
+}
+
+// 获取被适配的 IPacket
+func (pc *PacketCodec) Packet() ziface.IPacket {
+	return pc.packet
+}
+
+// 将任意编解码器适配为 IPacket，供仍在使用 Server.Packet 的代码封包
+// 编解码器没有固定长度的头部，GetHeadLen 返回 0，Unpack 返回 ErrPacketUnpackUnsupported
+type codecPacket struct {
+	codec ziface.IFrameCodec
+}
+
+func (cp *codecPacket) GetHeadLen() uint32 {
+	return 0
+}
+
+func (cp *codecPacket) Pack(msg ziface.IMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := cp.codec.Encode(&buf, msg); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (cp *codecPacket) Unpack([]byte) (ziface.IMessage, error) {
+	return nil, ErrPacketUnpackUnsupported
+}
+
+func NewPacketCodec(packet ziface.IPacket) *PacketCodec {
+	pc := &PacketCodec{packet: packet}
+	switch packet.(type) {
+	case *DataPack, *LengthFieldPacket:
+		pc.poolHead = true
+	}
+	return pc
+}
+
+// 统计读取字节数的 Reader
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += n
+	return n, err
+}
+
+// 取出上次调用以来读取的字节数
+func (cr *countingReader) take() int {
+	n := cr.n
+	cr.n = 0
+	return n
+}
+
+// 使用 codec 将消息编码为一帧，支持追加式编码时一次性分配内存
+func packMessage(codec ziface.IFrameCodec, msgID uint32, data []byte) ([]byte, error) {
+	msg := NewMessage(msgID, data)
+	if pc, ok := codec.(*PacketCodec); ok {
+		return pc.AppendEncode(make([]byte, 0, int(pc.packet.GetHeadLen())+len(data)), msg)
+	}
+	if ac, ok := codec.(ziface.IAppendCodec); ok {
+		return ac.AppendEncode(make([]byte, 0, len(data)+16), msg)
+	}
+	var buf bytes.Buffer
+	if err := codec.Encode(&buf, msg); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
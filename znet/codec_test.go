@@ -0,0 +1,97 @@
+package znet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/dokidokikoi/my-zinx/utils"
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+func TestPacketCodec(t *testing.T) {
+	codec := NewPacketCodec(NewDataPack())
+	var stream bytes.Buffer
+	for i, data := range []string{"hello", "", "zinx"} {
+		if err := codec.Encode(&stream, NewMessage(uint32(i+1), []byte(data))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 截断的最后一帧
+	packed, _ := packMessage(codec, 4, []byte("partial"))
+	stream.Write(packed[:10])
+
+	r := bufio.NewReader(&stream)
+	for i, want := range []string{"hello", "", "zinx"} {
+		msg, err := codec.Decode(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.GetMsgID() != uint32(i+1) || string(msg.GetData()) != want {
+			t.Fatalf("Decode = %d %q, want %d %q", msg.GetMsgID(), msg.GetData(), i+1, want)
+		}
+		if pm, ok := msg.(*pooledMessage); ok {
+			putBuff(pm.buf)
+		}
+	}
+	if _, err := codec.Decode(r); err != io.ErrUnexpectedEOF || !isReadError(err) {
+		t.Fatalf("Decode truncated frame err = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestPacketCodecTooLarge(t *testing.T) {
+	oldMax := utils.GlobalObject.MaxPacketSize
+	defer func() { utils.GlobalObject.MaxPacketSize = oldMax }()
+	utils.GlobalObject.MaxPacketSize = 4
+
+	codec := NewPacketCodec(NewDataPack())
+	packed, _ := packMessage(codec, 1, []byte("too large"))
+	_, err := codec.Decode(bufio.NewReader(bytes.NewReader(packed)))
+	if err == nil || isReadError(err) {
+		t.Fatalf("Decode oversize err = %v, want a format error", err)
+	}
+}
+
+// 在消息中保留头部切片的自定义 Packet
+type retainHeadPacket struct {
+	DataPack
+}
+
+type retainHeadMessage struct {
+	ziface.IMessage
+	head []byte
+}
+
+func (rp *retainHeadPacket) Unpack(binaryData []byte) (ziface.IMessage, error) {
+	msg, err := rp.DataPack.Unpack(binaryData)
+	if err != nil {
+		return nil, err
+	}
+	return &retainHeadMessage{IMessage: msg, head: binaryData}, nil
+}
+
+func TestPacketCodecRetainHead(t *testing.T) {
+	codec := NewPacketCodec(&retainHeadPacket{})
+	var stream bytes.Buffer
+	for i := uint32(1); i <= 3; i++ {
+		_ = codec.Encode(&stream, NewMessage(i, nil))
+	}
+
+	// 之后的拆包不会改写之前的消息引用的头部
+	r := bufio.NewReader(&stream)
+	var msgs []*retainHeadMessage
+	for i := 0; i < 3; i++ {
+		msg, err := codec.Decode(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg.(*retainHeadMessage))
+	}
+	for i, msg := range msgs {
+		if id := binary.LittleEndian.Uint32(msg.head[4:8]); id != uint32(i+1) {
+			t.Fatalf("msg %d retained head msgID = %d", i+1, id)
+		}
+	}
+}
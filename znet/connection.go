@@ -1,6 +1,7 @@
 package znet
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
@@ -20,11 +21,25 @@ type Connection struct {
 	Conn *net.TCPConn
 	// 当前连接的 ID, 也可以称为 SessionID, ID 全局唯一
 	ConnID uint32
+	// 对外暴露的连接，使用连接工厂或装饰器时为包装后的自定义连接，否则为自身
+	owner ziface.IConnection
 	// 当前连接的关闭状态
 	isClosed bool
+	// 连接的关闭原因
+	closeReason ziface.CloseReason
+	// 保护关闭原因的锁
+	closeReasonLock sync.Mutex
+	// 带缓冲的读取器，减少读取消息时的系统调用次数
+	reader *bufio.Reader
+	// 统计从 socket 读取的字节数，只在 Reader Goroutine 中使用
+	readCounter *countingReader
 
 	// 消息管理 MsgID 和对应处理方法的消息管理模块
 	MsgHandler ziface.IMsgHandler
+	// 未开启工作池且要求按序处理时使用的串行执行器
+	executor *serialExecutor
+	// 入站消息限流器，未配置限流时为 nil
+	limiter *connLimiter
 
 	// 告知该连接已经退出/停止的 channel
 	ctx    context.Context
@@ -32,22 +47,73 @@ type Connection struct {
 
 	// 有缓冲管道，用于读、写两个 Goroutine 之间的数据通信
 	msgBuffChan chan []byte
+	// 关闭时通知 Writer 发送剩余消息的 channel
+	flushChan chan struct{}
+	// 优雅关闭的截止时间，在 flushChan 关闭前设置
+	flushDeadline time.Time
+	// Reader、Writer 退出的通知 channel
+	readerDone chan struct{}
+	writerDone chan struct{}
 	sync.RWMutex
+	// 流量统计
+	stats connStats
+	// 保证写出消息与记录重放缓冲的顺序一致
+	writeLock sync.Mutex
+	// 会话恢复使用的重放缓冲，为 nil 时不记录
+	replay ziface.IReplayBuffer
 	// 连接属性
 	property map[string]interface{}
+	// 连接属性的监听函数，key 为空的监听全部属性
+	watchers map[string][]*propertyWatch
 	// 保护连接属性修改的锁
 	propertyLock sync.RWMutex
 }
 
 // 停止连接，结束当前连接状态 M
 func (c *Connection) Stop() {
+	c.StopWithReason(ziface.CloseNormal, nil)
+}
+
+// 携带关闭原因停止连接，多次调用时只保留第一次的原因
+func (c *Connection) StopWithReason(code ziface.CloseCode, err error) {
+	c.closeReasonLock.Lock()
+	if c.closeReason.Code == ziface.CloseNone {
+		c.closeReason = ziface.CloseReason{Code: code, Err: err}
+	}
+	c.closeReasonLock.Unlock()
+
 	c.cancel()
 }
 
+// 获取连接的关闭原因
+func (c *Connection) CloseReason() ziface.CloseReason {
+	c.closeReasonLock.Lock()
+	defer c.closeReasonLock.Unlock()
+
+	return c.closeReason
+}
+
+// 判断解码失败是否由读取连接出错导致，其余错误视为消息格式错误
+func isReadError(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) || errors.As(err, &netErr)
+}
+
+// 根据读取数据时的错误判断连接的关闭原因
+func readCloseCode(err error) ziface.CloseCode {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ziface.CloseTimeout
+	}
+	return ziface.CloseClientQuit
+}
+
 // 处理 conn 读数据的 Gorutine
 func (c *Connection) StartReader() {
 	fmt.Println("[Reader Goroutine is running]")
 	defer fmt.Println(c.Conn.RemoteAddr().String(), "[Conn Reader exit!]")
+	defer close(c.readerDone)
 	defer c.Stop()
 
 	for {
@@ -55,38 +121,50 @@ func (c *Connection) StartReader() {
 		case <-c.ctx.Done():
 			return
 		default:
-			// 读取客户端的 Msg Head
-			headData := make([]byte, c.TcpServer.Packet().GetHeadLen())
-			if _, err := io.ReadFull(c.GetTCPConnection(), headData); err != nil {
-				fmt.Println("read msg head error ", err)
-				return
-			}
-			// 拆包
-			msg, err := c.TcpServer.Packet().Unpack(headData)
+			// 从读缓冲中解码出一条完整的消息
+			msg, err := c.TcpServer.Codec().Decode(c.reader)
 			if err != nil {
-				fmt.Println("unpack error ", err)
+				if isReadError(err) {
+					fmt.Println("read msg error ", err)
+					c.StopWithReason(readCloseCode(err), err)
+				} else {
+					fmt.Println("unpack error ", err)
+					c.StopWithReason(ziface.CloseUnpackError, err)
+				}
 				return
 			}
+			// 消息体使用池化缓冲时，请求处理完成后归还
+			var buf *[]byte
+			if pm, ok := msg.(*pooledMessage); ok {
+				msg, buf = pm.IMessage, pm.buf
+			}
+			c.stats.addRead(c.readCounter.take())
 
-			// 根据 dataLen 读取数据
-			var data []byte
-			if msg.GetDataLen() > 0 {
-				data = make([]byte, msg.GetDataLen())
-				if _, err := io.ReadFull(c.GetTCPConnection(), data); err != nil {
-					fmt.Println("read msg data error ", err)
-					return
-				}
+			// 超出限流的消息不再分发
+			if c.limiter != nil && !c.checkRateLimit(msg.GetMsgID(), int(msg.GetDataLen())) {
+				msg.SetData(nil)
+				putBuff(buf)
+				continue
 			}
-			msg.SetData(data)
 
 			// 得到当前客户端请求的 Request 数据
 			req := Request{
-				conn: c,
+				conn: c.owner,
 				msg:  msg,
+				buf:  buf,
+				ctx:  c.ctx,
+				// 请求超时从开始执行处理方法时计算
+				timeout: time.Duration(utils.GlobalObject.RequestTimeout) * time.Millisecond,
 			}
 			if utils.GlobalObject.WorkerPoolSize > 0 {
 				// 已经启动 worker 工作池，将消息交给 worker
 				c.MsgHandler.SendMsg2TaskQueue(&req)
+			} else if c.executor != nil {
+				// 按到达顺序交给当前连接的串行执行器处理，待处理的请求过多时暂停读取
+				if !c.executor.Submit(&req, c.ctx.Done()) {
+					req.Release()
+					return
+				}
 			} else {
 				// 从绑定好的消息和对应的处理方法中执行对应的 Handle 方法
 				go c.MsgHandler.DoMsgHandler(&req)
@@ -95,42 +173,114 @@ func (c *Connection) StartReader() {
 	}
 }
 
+// 对读取到的消息执行限流，返回 false 表示该消息应被丢弃
+func (c *Connection) checkRateLimit(msgID uint32, size int) bool {
+	now := time.Now()
+	wait, perMsgID := c.limiter.check(msgID, size, now)
+	if wait == 0 {
+		c.limiter.take(msgID, size)
+		return true
+	}
+
+	strikes := c.limiter.strike(now)
+	c.TcpServer.CallOnRateLimit(c.owner, ziface.RateLimitEvent{
+		MsgID:    msgID,
+		DataLen:  uint32(size),
+		PerMsgID: perMsgID,
+		Policy:   c.limiter.policy,
+		Strikes:  strikes,
+	})
+
+	switch c.limiter.policy {
+	case RateLimitDelay:
+		// 暂停读取该连接，等待配额恢复后再处理
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-c.ctx.Done():
+			return false
+		case <-timer.C:
+		}
+		c.limiter.check(msgID, size, time.Now())
+		c.limiter.take(msgID, size)
+		return true
+	case RateLimitError:
+		_ = c.SendBuffMsg(utils.GlobalObject.RateLimitErrMsgID, []byte("rate limit exceeded"))
+	case RateLimitDisconnect:
+		if strikes >= utils.GlobalObject.RateLimitMaxStrikes {
+			c.StopWithReason(ziface.CloseRateLimited,
+				fmt.Errorf("rate limit exceeded %d times", strikes))
+		}
+	}
+	return false
+}
+
 func (c *Connection) finalizer() {
 	//如果用户注册了该链接的关闭回调业务，那么在此刻应该显示调用
-	c.TcpServer.CallOnConnStop(c)
-
-	c.Lock()
-	defer c.Unlock()
+	c.TcpServer.CallOnConnStop(c.owner)
 
 	//如果当前链接已经关闭
+	c.Lock()
 	if c.isClosed == true {
+		c.Unlock()
 		return
 	}
+	//设置标志位，此后不再接受新的发送请求
+	c.isClosed = true
+	c.Unlock()
+
+	fmt.Println("Conn Stop()...ConnID = ", c.ConnID, "reason = ", c.CloseReason())
 
-	fmt.Println("Conn Stop()...ConnID = ", c.ConnID)
+	// 停止读取客户端数据
+	_ = c.Conn.SetReadDeadline(time.Now())
 
-	// 关闭socket链接
+	// 通知 Writer 在截止时间内发送完缓冲中剩余的消息
+	c.flushDeadline = time.Now().Add(time.Duration(utils.GlobalObject.CloseFlushTimeout) * time.Millisecond)
+	close(c.flushChan)
+	c.waitDone(c.writerDone)
+
+	// 告知客户端连接关闭的原因
+	c.sendCloseFrame()
+
+	// 关闭写端，等待客户端读完数据后断开，再关闭 socket 链接
+	_ = c.Conn.CloseWrite()
+	if c.waitDone(c.readerDone) {
+		_ = c.Conn.SetReadDeadline(c.flushDeadline)
+		_, _ = io.Copy(io.Discard, c.Conn)
+	}
 	_ = c.Conn.Close()
 
-	//将链接从连接管理器中删除
-	c.TcpServer.GetConnMgr().Remove(c)
+	//解除用户绑定，将链接移出所有分组，并从连接管理器中删除
+	c.TcpServer.GetSessionMgr().Unbind(c.owner)
+	c.TcpServer.GetGroupMgr().LeaveAll(c.owner)
+	c.TcpServer.GetConnMgr().Remove(c.owner)
 
 	//关闭该链接全部管道
 	close(c.msgBuffChan)
-	//设置标志位
-	c.isClosed = true
+}
+
+// 在优雅关闭的截止时间内等待 done 关闭，超时返回 false
+func (c *Connection) waitDone(done chan struct{}) bool {
+	timer := time.NewTimer(time.Until(c.flushDeadline))
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
 }
 
 // 启动连接，让当前连接开始工作
 func (c *Connection) Start() {
-	c.ctx, c.cancel = context.WithCancel(context.Background())
 	// 开启处理该连接读取客户端数据的 Goroutine
 	go c.StartReader()
 	// 开启用于写回客户端的数据流程的 Goroutine
 	go c.StartWriter()
 
 	// 执行用户传入的构造方法
-	c.TcpServer.CallOnConnStart(c)
+	c.TcpServer.CallOnConnStart(c.owner)
 
 	for {
 		select {
@@ -158,19 +308,17 @@ func (c *Connection) SendMsg(msgID uint32, data []byte) error {
 	c.RLock()
 	defer c.RUnlock()
 	if c.isClosed {
-		return errors.New("Connection closed when send msg")
+		return ErrConnClosed
 	}
 	// 将 data 封包，并发送
-	msg, err := c.TcpServer.Packet().Pack(NewMessage(msgID, data))
+	msg, err := c.packMsg(msgID, data)
 	if err != nil {
 		fmt.Println("pack error msg id = ", msgID)
 		return errors.New("Pack error msg")
 	}
 
 	// 写回客户端
-	_, err = c.Conn.Write(msg)
-
-	return err
+	return c.write(msg)
 }
 
 func (c *Connection) SendBuffMsg(msgID uint32, data []byte) error {
@@ -180,10 +328,16 @@ func (c *Connection) SendBuffMsg(msgID uint32, data []byte) error {
 	defer idleTimeout.Stop()
 
 	if c.isClosed {
-		return errors.New("Connection closed when send msg")
+		return ErrConnClosed
 	}
+
+	// 客户端读取过慢时按策略处理
+	if err := c.checkSlowConsumer(msgID); err != nil {
+		return err
+	}
+
 	// 将 data 封包，并发送
-	msg, err := c.TcpServer.Packet().Pack(NewMessage(msgID, data))
+	msg, err := c.packMsg(msgID, data)
 	if err != nil {
 		fmt.Println("pack error msg id = ", msgID)
 		return errors.New("Pack error msg")
@@ -195,50 +349,210 @@ func (c *Connection) SendBuffMsg(msgID uint32, data []byte) error {
 	select {
 	case <-idleTimeout.C:
 		// 发送超时
+		c.stats.droppedSends.Add(1)
 		return errors.New("send buff msg timeout")
 	case c.msgBuffChan <- msg:
 		return nil
 	}
+}
+
+// 将已经封包好的消息以非阻塞的方式放入发送队列，队列已满时直接返回错误
+// packed 可能被多个连接共享，放入队列后不能再修改
+func (c *Connection) SendPackedBuffMsg(msgID uint32, packed []byte) error {
+	c.RLock()
+	defer c.RUnlock()
+
+	if c.isClosed {
+		return ErrConnClosed
+	}
+	if err := c.checkSlowConsumer(msgID); err != nil {
+		return err
+	}
+	return c.enqueue(packed)
+}
+
+// 以非阻塞的方式将消息放入发送队列，调用方需持有读锁并确认连接未关闭
+func (c *Connection) enqueue(packed []byte) error {
+	select {
+	case c.msgBuffChan <- packed:
+		return nil
+	default:
+		c.stats.droppedSends.Add(1)
+		return ErrSendQueueFull
+	}
+}
+
+// 客户端读取过慢时按策略处理，返回错误表示该消息不应发送
+func (c *Connection) checkSlowConsumer(msgID uint32) error {
+	c.updateSlowState()
+	if !c.isSlow() {
+		return nil
+	}
+	if utils.GlobalObject.SlowConsumerPolicy == SlowConsumerDisconnect {
+		c.stats.droppedSends.Add(1)
+		c.StopWithReason(ziface.CloseSlowConsumer, nil)
+		return errors.New("slow consumer disconnected")
+	}
+	if !isCriticalMsg(msgID) {
+		c.stats.droppedSends.Add(1)
+		return errors.New("skip msg for slow consumer")
+	}
+	return nil
+}
+
+// 在关闭 socket 之前发送关闭帧，客户端已断开或写失败时不再发送
+func (c *Connection) sendCloseFrame() {
+	if !utils.GlobalObject.SendCloseFrame || !supportsControlFrames(c.TcpServer.Codec()) {
+		return
+	}
+	reason := c.CloseReason()
+	if reason.Code == ziface.CloseClientQuit || reason.Code == ziface.CloseWriteError {
+		return
+	}
+
+	msg, err := c.packMsg(utils.GlobalObject.CloseFrameMsgID, packCloseFrameData(reason))
+	if err != nil {
+		fmt.Println("pack close frame error ", err)
+		return
+	}
+	// 避免客户端不读数据时阻塞关闭流程
+	_ = c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+	c.writeLock.Lock()
+	err = c.writeControl(msg)
+	c.writeLock.Unlock()
+	if err != nil {
+		fmt.Println("send close frame error ", err)
+	}
+}
 
+// 向客户端写数据，记录流量统计和重放缓冲
+// 写失败的消息同样记录到重放缓冲中，客户端恢复会话时会补发
+func (c *Connection) write(data []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	err := c.writeControl(data)
+	if c.replay != nil {
+		c.replay.Record(data)
+	}
+	return err
+}
+
+// 写出不计入重放缓冲的控制消息，调用方需持有 writeLock
+func (c *Connection) writeControl(data []byte) error {
+	if _, err := c.Conn.Write(data); err != nil {
+		return err
+	}
+	c.stats.addWrite(len(data))
 	return nil
 }
 
+// 依次写出 frames，然后开始将之后写出的消息记录到重放缓冲中
+// frames 用于发送会话恢复相关的控制消息，它们不会被记录
+func (c *Connection) startReplay(buf ziface.IReplayBuffer, frames ...[]byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	for _, frame := range frames {
+		if err := c.writeControl(frame); err != nil {
+			return err
+		}
+	}
+	c.replay = buf
+	return nil
+}
+
+// 停止记录重放缓冲，返回之前使用的重放缓冲
+func (c *Connection) stopReplay() ziface.IReplayBuffer {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	buf := c.replay
+	c.replay = nil
+	return buf
+}
+
+// 获取连接的流量统计信息
+func (c *Connection) Stats() ziface.ConnStats {
+	stats := c.stats.snapshot()
+	stats.QueueLen = len(c.msgBuffChan)
+	stats.Slow = c.isSlow()
+	return stats
+}
+
+// 根据输出队列的长度更新慢消费者状态
+func (c *Connection) updateSlowState() {
+	highWater := utils.GlobalObject.SlowConsumerHighWater
+	if highWater == 0 {
+		return
+	}
+	if uint32(len(c.msgBuffChan)) >= highWater {
+		c.stats.highWaterSince.CompareAndSwap(0, time.Now().UnixNano())
+	} else {
+		c.stats.highWaterSince.Store(0)
+	}
+}
+
+// 输出队列持续高于高水位超过阈值时，连接被视为慢消费者
+func (c *Connection) isSlow() bool {
+	since := c.stats.highWaterSince.Load()
+	if since == 0 {
+		return false
+	}
+	threshold := time.Duration(utils.GlobalObject.SlowConsumerThreshold) * time.Millisecond
+	return time.Since(time.Unix(0, since)) >= threshold
+}
+
+// 使用 Server 的封包方式将消息封包
+func (c *Connection) packMsg(msgID uint32, data []byte) ([]byte, error) {
+	return packMessage(c.TcpServer.Codec(), msgID, data)
+}
+
 // 读写分离，职责单一，在优化读或写逻辑时互不干扰
 func (c *Connection) StartWriter() {
 	fmt.Println("[Writer Goroutine is running]")
 	defer fmt.Println(c.RemoteAddr().String(), "[Conn Writer exit!]")
+	defer close(c.writerDone)
 	defer c.Stop()
 
 	for {
 		select {
-		case data, ok := <-c.msgBuffChan:
-			// 针对有缓冲的 chan 需要进行数据处理
-			if ok {
-				if _, err := c.Conn.Write(data); err != nil {
-					fmt.Printf("Send Data error: %v, Conn Writer exit", err)
-					return
-				}
-			} else {
-				break
-				fmt.Println("msgBuffChan is Closed")
+		case data := <-c.msgBuffChan:
+			if err := c.write(data); err != nil {
+				fmt.Printf("Send Data error: %v, Conn Writer exit", err)
+				c.StopWithReason(ziface.CloseWriteError, err)
+				return
 			}
-		case <-c.ctx.Done():
-			// conn 已经关闭
+			c.updateSlowState()
+		case <-c.flushChan:
+			// conn 正在关闭，发送完缓冲中剩余的消息后退出
+			c.flushBuffMsg()
 			return
 		}
 	}
 }
 
-// 设置连接属性
-func (c *Connection) SetProperty(key string, value interface{}) {
-	c.propertyLock.Lock()
-	defer c.propertyLock.Unlock()
-
-	if c.property == nil {
-		c.property = make(map[string]interface{})
+// 在截止时间内将 msgBuffChan 中剩余的消息写回客户端
+func (c *Connection) flushBuffMsg() {
+	_ = c.Conn.SetWriteDeadline(c.flushDeadline)
+	for {
+		select {
+		case data := <-c.msgBuffChan:
+			if err := c.write(data); err != nil {
+				fmt.Println("flush buff msg error ", err)
+				return
+			}
+		default:
+			return
+		}
 	}
+}
 
-	c.property[key] = value
+// 设置连接属性
+func (c *Connection) SetProperty(key string, value interface{}) {
+	c.UpdateProperty(key, func(interface{}, bool) (interface{}, ziface.PropertyAction) {
+		return value, ziface.PropertySet
+	})
 }
 
 // 获取连接属性
@@ -248,17 +562,101 @@ func (c *Connection) GetProperty(key string) (interface{}, error) {
 
 	val, ok := c.property[key]
 	if !ok {
-		return nil, errors.New("no property found")
+		return nil, ErrPropertyNotFound
 	}
 	return val, nil
 }
 
+// 获取全部连接属性的副本
+func (c *Connection) GetProperties() map[string]interface{} {
+	c.propertyLock.RLock()
+	defer c.propertyLock.RUnlock()
+
+	properties := make(map[string]interface{}, len(c.property))
+	for key, value := range c.property {
+		properties[key] = value
+	}
+	return properties
+}
+
 // 移除连接属性
 func (c *Connection) RemoveProperty(key string) {
+	c.UpdateProperty(key, func(interface{}, bool) (interface{}, ziface.PropertyAction) {
+		return nil, ziface.PropertyRemove
+	})
+}
+
+// 原子地更新连接属性，属性发生变化后通知监听函数
+func (c *Connection) UpdateProperty(key string, updater ziface.PropertyUpdater) (interface{}, bool) {
+	c.propertyLock.Lock()
+	if c.property == nil {
+		c.property = make(map[string]interface{})
+	}
+
+	old, oldExists := c.property[key]
+	value, action := updater(old, oldExists)
+	change := ziface.PropertyChange{
+		Key:       key,
+		Old:       old,
+		OldExists: oldExists,
+	}
+	switch action {
+	case ziface.PropertySet:
+		c.property[key] = value
+		change.New, change.NewExists = value, true
+	case ziface.PropertyRemove:
+		delete(c.property, key)
+	default:
+		c.propertyLock.Unlock()
+		return old, oldExists
+	}
+	watchers := c.propertyWatchers(key)
+	c.propertyLock.Unlock()
+
+	if change.OldExists || change.NewExists {
+		for _, w := range watchers {
+			w.fn(c.owner, change)
+		}
+	}
+	return change.New, change.NewExists
+}
+
+// 监听连接属性的变化，key 为空时监听全部属性
+func (c *Connection) WatchProperty(key string, watcher ziface.PropertyWatcher) func() {
+	w := &propertyWatch{fn: watcher}
+
 	c.propertyLock.Lock()
-	defer c.propertyLock.Unlock()
+	if c.watchers == nil {
+		c.watchers = make(map[string][]*propertyWatch)
+	}
+	c.watchers[key] = append(c.watchers[key], w)
+	c.propertyLock.Unlock()
+
+	return func() {
+		c.propertyLock.Lock()
+		defer c.propertyLock.Unlock()
+
+		list := c.watchers[key]
+		for i := range list {
+			if list[i] == w {
+				c.watchers[key] = append(list[:i:i], list[i+1:]...)
+				break
+			}
+		}
+	}
+}
 
-	delete(c.property, key)
+// 复制一份需要通知的监听函数，调用方需持有 propertyLock
+func (c *Connection) propertyWatchers(key string) []*propertyWatch {
+	if len(c.watchers) == 0 {
+		return nil
+	}
+	watchers := make([]*propertyWatch, 0, len(c.watchers[key])+len(c.watchers[""]))
+	watchers = append(watchers, c.watchers[key]...)
+	if key != "" {
+		watchers = append(watchers, c.watchers[""]...)
+	}
+	return watchers
 }
 
 // 返回ctx，用于用户自定义的go程获取连接退出状态
@@ -266,7 +664,18 @@ func (c *Connection) Context() context.Context {
 	return c.ctx
 }
 
+// 创建连接并添加到 server 的连接管理器中，之后调用 Start 启动
+// 在 ConnFactory 中创建被嵌入的 *Connection 时使用 NewBaseConnection
 func NewConnection(server ziface.IServer, conn *net.TCPConn, connID uint32, msgHandler ziface.IMsgHandler) *Connection {
+	c := NewBaseConnection(server, conn, connID, msgHandler)
+	// 将新创建的 Conn 添加到连接管理中
+	server.GetConnMgr().Add(c)
+	return c
+}
+
+// 创建连接但不添加到连接管理器，用于在 ConnFactory 中创建被嵌入的 *Connection，
+// Server 应用装饰器后将最终对外使用的连接添加到连接管理器
+func NewBaseConnection(server ziface.IServer, conn *net.TCPConn, connID uint32, msgHandler ziface.IMsgHandler) *Connection {
 	c := &Connection{
 		TcpServer:   server,
 		Conn:        conn,
@@ -274,11 +683,41 @@ func NewConnection(server ziface.IServer, conn *net.TCPConn, connID uint32, msgH
 		MsgHandler:  msgHandler,
 		isClosed:    false,
 		msgBuffChan: make(chan []byte, utils.GlobalObject.MaxMsgChanLen),
+		flushChan:   make(chan struct{}),
+		readerDone:  make(chan struct{}),
+		writerDone:  make(chan struct{}),
 		property:    make(map[string]interface{}),
+		readCounter: &countingReader{r: conn},
 	}
+	c.reader = bufio.NewReaderSize(c.readCounter, int(utils.GlobalObject.IOReadBuffSize))
 
-	// 将新创建的 Conn 添加到连接管理中
-	c.TcpServer.GetConnMgr().Add(c)
+	c.owner = c
+	// 在 context 中保存自身，装饰器包装后的连接也能通过 Context 找到内部的 Connection
+	c.ctx, c.cancel = context.WithCancel(context.WithValue(context.Background(), baseConnKey{}, c))
+	c.stats.connectTime = time.Now()
+
+	c.limiter = newConnLimiter()
+
+	if utils.GlobalObject.WorkerPoolSize == 0 && utils.GlobalObject.OrderedMsgHandle {
+		c.executor = newSerialExecutor(msgHandler, int(utils.GlobalObject.MaxWorkerTaskLen))
+	}
 
 	return c
 }
+
+// 获取自身，嵌入 *Connection 的自定义连接类型通过该方法找到内部的 Connection
+func (c *Connection) baseConnection() *Connection {
+	return c
+}
+
+// 连接 context 中保存内部 Connection 的 key
+type baseConnKey struct{}
+
+// 找到 conn 内部的 *Connection，conn 可以是嵌入 *Connection 的类型或装饰器包装后的连接
+func baseConnOf(conn ziface.IConnection) (*Connection, bool) {
+	if base, ok := conn.(connectionBase); ok {
+		return base.baseConnection(), true
+	}
+	base, ok := conn.Context().Value(baseConnKey{}).(*Connection)
+	return base, ok
+}
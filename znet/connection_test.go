@@ -0,0 +1,60 @@
+package znet
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestConnectionFlushBeforeClose(t *testing.T) {
+	s := newTestServer(t)
+	client, conn := dialTestServer(t, s)
+
+	// 客户端一直读到 FIN
+	type result struct {
+		msgs []string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var res result
+		r := bufio.NewReader(client)
+		_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			msg, err := s.Codec().Decode(r)
+			if err != nil {
+				if err != io.EOF {
+					res.err = err
+				}
+				done <- res
+				return
+			}
+			res.msgs = append(res.msgs, string(msg.GetData()))
+		}
+	}()
+
+	// 关闭前已经放入发送队列的消息全部写出后才发送 FIN
+	const total = 200
+	payload := make([]byte, 1000)
+	for i := 0; i < total; i++ {
+		if err := conn.SendBuffMsg(1, append([]byte(fmt.Sprintf("%03d", i)), payload...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.Stop()
+
+	res := <-done
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if len(res.msgs) != total {
+		t.Fatalf("received %d msgs before FIN, want %d", len(res.msgs), total)
+	}
+	for i, msg := range res.msgs {
+		if msg[:3] != fmt.Sprintf("%03d", i) {
+			t.Fatalf("msg %d = %q", i, msg[:3])
+		}
+	}
+}
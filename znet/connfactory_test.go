@@ -0,0 +1,112 @@
+package znet
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+// 连接工厂创建的自定义连接
+type factoryTestConn struct {
+	*Connection
+}
+
+// 给 SendMsg 发送的数据加上前缀的装饰器
+type prefixSendConn struct {
+	ziface.IConnection
+	prefix string
+}
+
+func (c *prefixSendConn) SendMsg(msgID uint32, data []byte) error {
+	return c.IConnection.SendMsg(msgID, append([]byte(c.prefix), data...))
+}
+
+// 记录请求所属的连接并原样发回
+type connRecordRouter struct {
+	BaseRouter
+	conns chan ziface.IConnection
+}
+
+func (r *connRecordRouter) Handle(request ziface.IRequest) {
+	r.conns <- request.GetConnection()
+	_ = request.GetConnection().SendMsg(request.GetMsgID(), request.GetData())
+}
+
+func TestConnFactoryDecorator(t *testing.T) {
+	s := newTestServer(t,
+		WithConnFactory(func(server ziface.IServer, conn *net.TCPConn, connID uint32, msgHandler ziface.IMsgHandler) ziface.IConnection {
+			return &factoryTestConn{NewConnection(server, conn, connID, msgHandler)}
+		}),
+		WithConnDecorator(func(conn ziface.IConnection) ziface.IConnection {
+			return &prefixSendConn{IConnection: conn, prefix: "a:"}
+		}),
+		WithConnDecorator(func(conn ziface.IConnection) ziface.IConnection {
+			return &prefixSendConn{IConnection: conn, prefix: "b:"}
+		}),
+	)
+	started := make(chan ziface.IConnection, 1)
+	stopped := make(chan ziface.IConnection, 1)
+	s.SetOnConnStart(func(conn ziface.IConnection) { started <- conn })
+	s.SetOnConnStop(func(conn ziface.IConnection) { stopped <- conn })
+	router := &connRecordRouter{conns: make(chan ziface.IConnection, 1)}
+	s.AddRouter(1, router)
+
+	client, conn := dialTestServer(t, s)
+	// 装饰器按添加顺序包装，最外层是最后添加的装饰器
+	outer, ok := conn.(*prefixSendConn)
+	if !ok || outer.prefix != "b:" {
+		t.Fatalf("conn = %T, want the last decorator", conn)
+	}
+	inner, ok := outer.IConnection.(*prefixSendConn)
+	if !ok || inner.prefix != "a:" {
+		t.Fatalf("inner conn = %T, want the first decorator", outer.IConnection)
+	}
+	if _, ok := inner.IConnection.(*factoryTestConn); !ok {
+		t.Fatalf("base conn = %T, want the factory conn", inner.IConnection)
+	}
+
+	// hook、连接管理和路由拿到的都是包装后的连接
+	if got := <-started; got != conn {
+		t.Fatalf("OnConnStart got %T, want the decorated conn", got)
+	}
+	if got, err := s.GetConnMgr().Get(conn.GetConnID()); err != nil || got != conn {
+		t.Fatalf("ConnMgr.Get = %T, %v, want the decorated conn", got, err)
+	}
+	r := bufio.NewReader(client)
+	writeTestFrame(t, client, s.Codec(), 1, []byte("hi"))
+	if got := <-router.conns; got != conn {
+		t.Fatalf("request conn = %T, want the decorated conn", got)
+	}
+	// 路由中的 SendMsg 经过了两层装饰器
+	if msgID, data := readTestFrame(t, client, r, s.Codec()); msgID != 1 || data != "a:b:hi" {
+		t.Fatalf("reply = %d %q, want 1 %q", msgID, data, "a:b:hi")
+	}
+
+	_ = client.Close()
+	if got := <-stopped; got != conn {
+		t.Fatalf("OnConnStop got %T, want the decorated conn", got)
+	}
+}
+
+func TestConnFactoryNewConnection(t *testing.T) {
+	// 旧的连接工厂使用 NewConnection，内部连接先加入了连接管理器
+	s := newTestServer(t,
+		WithConnFactory(func(server ziface.IServer, conn *net.TCPConn, connID uint32, msgHandler ziface.IMsgHandler) ziface.IConnection {
+			return &factoryTestConn{NewConnection(server, conn, connID, msgHandler)}
+		}),
+		WithConnDecorator(func(conn ziface.IConnection) ziface.IConnection {
+			return &prefixSendConn{IConnection: conn}
+		}),
+	)
+	_, conn := dialTestServer(t, s)
+
+	// 包装后的连接替换内部连接，不重复计数
+	if got, err := s.GetConnMgr().Get(conn.GetConnID()); err != nil || got != conn {
+		t.Fatalf("ConnMgr.Get = %T, %v, want the decorated conn", got, err)
+	}
+	if n, total := s.GetConnMgr().Len(), s.GetConnMgr().Stats().TotalConns; n != 1 || total != 1 {
+		t.Fatalf("Len = %d, TotalConns = %d, want 1 and 1", n, total)
+	}
+}
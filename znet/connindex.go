@@ -0,0 +1,181 @@
+package znet
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+// 已建立索引的连接的状态
+type indexedConn struct {
+	conn ziface.IConnection
+	// 属性名 -> 当前被索引的属性值
+	values map[string]interface{}
+	// 属性名 -> 属性监听的取消函数
+	cancels map[string]func()
+}
+
+// 基于连接属性的二级索引，由连接管理器在添加、移除连接时维护
+type connIndexes struct {
+	lock sync.RWMutex
+	// 属性名 -> 属性值 -> ConnID -> 连接
+	indexes map[string]map[interface{}]map[uint32]ziface.IConnection
+	// 已建立索引的连接
+	conns map[uint32]*indexedConn
+}
+
+func newConnIndexes() *connIndexes {
+	return &connIndexes{
+		indexes: make(map[string]map[interface{}]map[uint32]ziface.IConnection),
+		conns:   make(map[uint32]*indexedConn),
+	}
+}
+
+// 只有可比较的属性值才能作为索引的 key
+func indexable(value interface{}) bool {
+	return value != nil && reflect.TypeOf(value).Comparable()
+}
+
+// 连接是否已经开始关闭，正在关闭的连接即将被移除，不再加入索引
+func connClosing(conn ziface.IConnection) bool {
+	ctx := conn.Context()
+	return ctx != nil && ctx.Err() != nil
+}
+
+// 为属性建立索引，conns 返回连接管理器中当前的全部连接
+// 先创建索引再获取连接，之后添加的连接会在 add 中加入索引
+func (ci *connIndexes) addIndex(property string, conns func() []ziface.IConnection) {
+	ci.lock.Lock()
+	if _, ok := ci.indexes[property]; ok {
+		ci.lock.Unlock()
+		return
+	}
+	ci.indexes[property] = make(map[interface{}]map[uint32]ziface.IConnection)
+	ci.lock.Unlock()
+
+	existing := conns()
+
+	ci.lock.Lock()
+	defer ci.lock.Unlock()
+	for _, conn := range existing {
+		if connClosing(conn) {
+			continue
+		}
+		ci.watch(ci.track(conn), property)
+	}
+}
+
+// 连接被添加到连接管理器，没有任何索引时不做处理
+func (ci *connIndexes) add(conn ziface.IConnection) {
+	ci.lock.RLock()
+	empty := len(ci.indexes) == 0
+	ci.lock.RUnlock()
+	if empty {
+		return
+	}
+
+	ci.lock.Lock()
+	defer ci.lock.Unlock()
+
+	ic := ci.track(conn)
+	for property := range ci.indexes {
+		ci.watch(ic, property)
+	}
+}
+
+// 获取或创建连接的索引状态，调用方需持有 lock
+func (ci *connIndexes) track(conn ziface.IConnection) *indexedConn {
+	ic, ok := ci.conns[conn.GetConnID()]
+	if !ok {
+		ic = &indexedConn{
+			conn:    conn,
+			values:  make(map[string]interface{}),
+			cancels: make(map[string]func()),
+		}
+		ci.conns[conn.GetConnID()] = ic
+	}
+	return ic
+}
+
+// 监听连接的属性变化并索引属性的当前值，调用方需持有 lock
+// 先注册监听再读取当前值，保证读取之后发生的修改都会通过监听更新索引
+// 并发修改时监听函数的执行顺序可能与修改顺序不同，因此监听中重新读取属性的当前值，而不使用 change.New
+func (ci *connIndexes) watch(ic *indexedConn, property string) {
+	if _, ok := ic.cancels[property]; ok {
+		return
+	}
+	conn := ic.conn
+	ic.cancels[property] = conn.WatchProperty(property, func(ziface.IConnection, ziface.PropertyChange) {
+		ci.lock.Lock()
+		defer ci.lock.Unlock()
+		value, err := conn.GetProperty(property)
+		ci.update(conn.GetConnID(), property, value, err == nil)
+	})
+	connID := conn.GetConnID()
+
+	value, err := ic.conn.GetProperty(property)
+	ci.update(connID, property, value, err == nil)
+}
+
+// 更新连接在某个属性索引中的位置，调用方需持有 lock
+func (ci *connIndexes) update(connID uint32, property string, value interface{}, exists bool) {
+	ic, ok := ci.conns[connID]
+	if !ok {
+		// 连接已经被移除
+		return
+	}
+	index := ci.indexes[property]
+
+	if old, ok := ic.values[property]; ok {
+		delete(index[old], connID)
+		if len(index[old]) == 0 {
+			delete(index, old)
+		}
+		delete(ic.values, property)
+	}
+
+	if !exists || !indexable(value) {
+		return
+	}
+	if index[value] == nil {
+		index[value] = make(map[uint32]ziface.IConnection)
+	}
+	index[value][connID] = ic.conn
+	ic.values[property] = value
+}
+
+// 连接从连接管理器中移除，从所有索引中删除并取消监听
+func (ci *connIndexes) remove(conn ziface.IConnection) {
+	ci.lock.Lock()
+	ic, ok := ci.conns[conn.GetConnID()]
+	if !ok {
+		ci.lock.Unlock()
+		return
+	}
+	for property := range ic.values {
+		ci.update(conn.GetConnID(), property, nil, false)
+	}
+	delete(ci.conns, conn.GetConnID())
+	ci.lock.Unlock()
+
+	for _, cancel := range ic.cancels {
+		cancel()
+	}
+}
+
+// 通过属性值查找连接
+func (ci *connIndexes) get(property string, value interface{}) []ziface.IConnection {
+	if !indexable(value) {
+		return nil
+	}
+
+	ci.lock.RLock()
+	defer ci.lock.RUnlock()
+
+	conns := make([]ziface.IConnection, 0, len(ci.indexes[property][value]))
+	for _, conn := range ci.indexes[property][value] {
+		conns = append(conns, conn)
+	}
+	return conns
+}
@@ -1,7 +1,6 @@
 package znet
 
 import (
-	"errors"
 	"fmt"
 	"github.com/dokidokikoi/my-zinx/ziface"
 	"sync"
@@ -12,6 +11,14 @@ type ConnManager struct {
 	connection map[uint32]ziface.IConnection
 	// 读写连接的读写锁
 	connLock sync.RWMutex
+	// 已移除连接的流量汇总
+	closedStats ziface.ConnMgrStats
+	// 累计添加的连接数
+	totalConns uint64
+	// 基于连接属性的二级索引
+	indexes *connIndexes
+	// 广播时使用的编解码器
+	codec ziface.IFrameCodec
 }
 
 func (cm *ConnManager) Len() int {
@@ -21,13 +28,24 @@ func (cm *ConnManager) Len() int {
 	return length
 }
 
+// 添加连接，同一 ConnID 再次添加时替换原来的连接，
+// 例如 ConnFactory 中使用 NewConnection 创建的连接被装饰后由 Server 重新添加
 func (cm *ConnManager) Add(conn ziface.IConnection) {
 	// 保护共享资源， map 加写锁
 	cm.connLock.Lock()
+	old, replaced := cm.connection[conn.GetConnID()]
 	// 将连接添加到 map 中
 	cm.connection[conn.GetConnID()] = conn
+	if !replaced {
+		cm.totalConns++
+	}
 	cm.connLock.Unlock()
 
+	if replaced {
+		cm.indexes.remove(old)
+	}
+	cm.indexes.add(conn)
+
 	fmt.Printf("connection add to ConnManager successfully: conn num=%d\n", cm.Len())
 }
 
@@ -35,9 +53,15 @@ func (cm *ConnManager) Add(conn ziface.IConnection) {
 func (cm *ConnManager) Remove(conn ziface.IConnection) {
 	// 保护共享资源， map 加写锁
 	cm.connLock.Lock()
-	delete(cm.connection, conn.GetConnID())
+	if _, ok := cm.connection[conn.GetConnID()]; ok {
+		// 保留已移除连接的流量统计
+		cm.closedStats.Add(conn.Stats())
+		delete(cm.connection, conn.GetConnID())
+	}
 	cm.connLock.Unlock()
 
+	cm.indexes.remove(conn)
+
 	fmt.Printf("connection Remove ConnID=%d successfully: conn num=%d\n", conn.GetConnID(), cm.Len())
 }
 
@@ -49,7 +73,7 @@ func (cm *ConnManager) Get(connID uint32) (ziface.IConnection, error) {
 	if conn, ok := cm.connection[connID]; ok {
 		return conn, nil
 	}
-	return nil, errors.New("connection not found")
+	return nil, ErrConnNotFound
 }
 
 func (cm *ConnManager) ClearConn() {
@@ -58,7 +82,8 @@ func (cm *ConnManager) ClearConn() {
 
 	// 停止并删除所有连接信息
 	for connID, conn := range cm.connection {
-		conn.Stop()
+		conn.StopWithReason(ziface.CloseServerShutdown, nil)
+		cm.closedStats.Add(conn.Stats())
 		delete(cm.connection, connID)
 	}
 	cm.connLock.Unlock()
@@ -66,8 +91,90 @@ func (cm *ConnManager) ClearConn() {
 	fmt.Printf("Clear All Connection successfully: conn num=%d\n", cm.Len())
 }
 
+func (cm *ConnManager) Stats() ziface.ConnMgrStats {
+	cm.connLock.RLock()
+	defer cm.connLock.RUnlock()
+
+	stats := cm.closedStats
+	stats.Conns = len(cm.connection)
+	stats.TotalConns = cm.totalConns
+	for _, conn := range cm.connection {
+		connStats := conn.Stats()
+		stats.Add(connStats)
+		stats.QueueLen += connStats.QueueLen
+		if connStats.Slow {
+			stats.SlowConns++
+		}
+	}
+	return stats
+}
+
+func (cm *ConnManager) Range(fn func(conn ziface.IConnection) bool) {
+	cm.connLock.RLock()
+	conns := make([]ziface.IConnection, 0, len(cm.connection))
+	for _, conn := range cm.connection {
+		conns = append(conns, conn)
+	}
+	cm.connLock.RUnlock()
+
+	for _, conn := range conns {
+		if !fn(conn) {
+			return
+		}
+	}
+}
+
+func (cm *ConnManager) Filter(fn func(conn ziface.IConnection) bool) []ziface.IConnection {
+	var conns []ziface.IConnection
+	cm.Range(func(conn ziface.IConnection) bool {
+		if fn(conn) {
+			conns = append(conns, conn)
+		}
+		return true
+	})
+	return conns
+}
+
+func (cm *ConnManager) AddIndex(property string) {
+	cm.indexes.addIndex(property, func() []ziface.IConnection {
+		return cm.Filter(func(ziface.IConnection) bool { return true })
+	})
+}
+
+func (cm *ConnManager) GetByIndex(property string, value interface{}) []ziface.IConnection {
+	return cm.indexes.get(property, value)
+}
+
+func (cm *ConnManager) SetCodec(codec ziface.IFrameCodec) {
+	cm.codec = codec
+}
+
+func (cm *ConnManager) Broadcast(msgID uint32, data []byte) []ziface.SendResult {
+	return cm.BroadcastFilter(func(ziface.IConnection) bool { return true }, msgID, data)
+}
+
+func (cm *ConnManager) Multicast(connIDs []uint32, msgID uint32, data []byte) []ziface.SendResult {
+	conns := make([]ziface.IConnection, 0, len(connIDs))
+	var missing []ziface.SendResult
+	for _, connID := range connIDs {
+		conn, err := cm.Get(connID)
+		if err != nil {
+			missing = append(missing, ziface.SendResult{ConnID: connID, Err: err})
+			continue
+		}
+		conns = append(conns, conn)
+	}
+	return append(broadcastPacked(cm.codec, conns, msgID, data), missing...)
+}
+
+func (cm *ConnManager) BroadcastFilter(fn func(conn ziface.IConnection) bool, msgID uint32, data []byte) []ziface.SendResult {
+	return broadcastPacked(cm.codec, cm.Filter(fn), msgID, data)
+}
+
 func NewConnManager() *ConnManager {
 	return &ConnManager{
 		connection: make(map[uint32]ziface.IConnection),
+		indexes:    newConnIndexes(),
+		codec:      NewPacketCodec(NewDataPack()),
 	}
 }
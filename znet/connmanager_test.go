@@ -0,0 +1,285 @@
+package znet
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+func testConnManagers() map[string]func() ziface.IConnManager {
+	return map[string]func() ziface.IConnManager{
+		"single":    func() ziface.IConnManager { return NewConnManager() },
+		"sharded16": func() ziface.IConnManager { return NewShardedConnManager(16) },
+		"sharded64": func() ziface.IConnManager { return NewShardedConnManager(64) },
+	}
+}
+
+// 预先添加 n 个连接
+func fillConnManager(cm ziface.IConnManager, n int) {
+	for i := 0; i < n; i++ {
+		cm.Add(&Connection{ConnID: uint32(i)})
+	}
+}
+
+func TestShardedConnManager(t *testing.T) {
+	cm := NewShardedConnManager(8)
+	fillConnManager(cm, 100)
+	if cm.Len() != 100 {
+		t.Fatalf("Len = %d, want 100", cm.Len())
+	}
+	conn, err := cm.Get(42)
+	if err != nil || conn.GetConnID() != 42 {
+		t.Fatalf("Get(42) = %v, %v", conn, err)
+	}
+	cm.Remove(conn)
+	if _, err := cm.Get(42); err == nil {
+		t.Fatal("Get after Remove should fail")
+	}
+	if stats := cm.Stats(); stats.Conns != 99 || stats.TotalConns != 100 {
+		t.Fatalf("Stats = %+v", stats)
+	}
+}
+
+// 创建发送队列长度为 1、没有 Writer 的连接
+func newTestQueueConnection(connID uint32) *Connection {
+	c := newTestConnection(connID)
+	c.msgBuffChan = make(chan []byte, 1)
+	return c
+}
+
+// 在超时时间内完成调用，用于确认发送不会阻塞
+func callWithin(t *testing.T, name string, fn func() []ziface.SendResult) map[uint32]error {
+	t.Helper()
+	done := make(chan []ziface.SendResult, 1)
+	go func() { done <- fn() }()
+	select {
+	case results := <-done:
+		errs := make(map[uint32]error, len(results))
+		for _, r := range results {
+			errs[r.ConnID] = r.Err
+		}
+		return errs
+	case <-time.After(time.Second):
+		t.Fatalf("%s blocked", name)
+		return nil
+	}
+}
+
+func TestConnManagerBroadcast(t *testing.T) {
+	for name, newCM := range testConnManagers() {
+		t.Run(name, func(t *testing.T) {
+			cm := newCM()
+			conns := make([]*Connection, 4)
+			for i := range conns {
+				conns[i] = newTestQueueConnection(uint32(i + 1))
+				cm.Add(conns[i])
+			}
+			// conn 3 的发送队列已满，conn 4 正在关闭
+			conns[2].msgBuffChan <- []byte("queued")
+			conns[3].cancel()
+
+			errs := callWithin(t, "Broadcast", func() []ziface.SendResult { return cm.Broadcast(5, []byte("hi")) })
+			want := map[uint32]error{1: nil, 2: nil, 3: ErrSendQueueFull, 4: ErrConnClosed}
+			for connID, err := range want {
+				if got, ok := errs[connID]; !ok || got != err {
+					t.Fatalf("conn %d result = %v, want %v", connID, got, err)
+				}
+			}
+			if conns[2].Stats().DroppedSends != 1 {
+				t.Fatalf("dropped sends = %d, want 1", conns[2].Stats().DroppedSends)
+			}
+
+			// 所有连接共享同一份编码结果
+			p1, p2 := <-conns[0].msgBuffChan, <-conns[1].msgBuffChan
+			if &p1[0] != &p2[0] {
+				t.Fatal("broadcast encoded the message more than once")
+			}
+			msg, err := NewPacketCodec(NewDataPack()).Decode(bufio.NewReader(bytes.NewReader(p1)))
+			if err != nil || msg.GetMsgID() != 5 || string(msg.GetData()) != "hi" {
+				t.Fatalf("queued frame = %v, %v", msg, err)
+			}
+
+			errs = callWithin(t, "Multicast", func() []ziface.SendResult { return cm.Multicast([]uint32{1, 99}, 6, nil) })
+			if len(errs) != 2 || errs[1] != nil || errs[99] != ErrConnNotFound {
+				t.Fatalf("Multicast results = %v", errs)
+			}
+			errs = callWithin(t, "BroadcastFilter", func() []ziface.SendResult {
+				return cm.BroadcastFilter(func(conn ziface.IConnection) bool { return conn.GetConnID() == 2 }, 7, nil)
+			})
+			if len(errs) != 1 || errs[2] != nil {
+				t.Fatalf("BroadcastFilter results = %v", errs)
+			}
+
+			// 已经关闭的连接不再接受消息
+			conns[1].isClosed = true
+			if err := conns[1].SendPackedBuffMsg(8, p1); err != ErrConnClosed {
+				t.Fatalf("SendPackedBuffMsg on closed conn err = %v", err)
+			}
+		})
+	}
+}
+
+func BenchmarkConnManagerAddRemove(b *testing.B) {
+	for name, newCM := range testConnManagers() {
+		b.Run(name, func(b *testing.B) {
+			cm := newCM()
+			var id uint32
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					conn := &Connection{ConnID: atomic.AddUint32(&id, 1)}
+					cm.Add(conn)
+					cm.Remove(conn)
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkConnManagerGet(b *testing.B) {
+	for name, newCM := range testConnManagers() {
+		b.Run(name, func(b *testing.B) {
+			cm := newCM()
+			fillConnManager(cm, 10000)
+			var id uint32
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					_, _ = cm.Get(atomic.AddUint32(&id, 1) % 10000)
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkConnManagerMixed(b *testing.B) {
+	for name, newCM := range testConnManagers() {
+		b.Run(name, func(b *testing.B) {
+			cm := newCM()
+			fillConnManager(cm, 10000)
+			var id uint32
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					n := atomic.AddUint32(&id, 1)
+					switch n % 4 {
+					case 0:
+						conn := &Connection{ConnID: 10000 + n}
+						cm.Add(conn)
+						cm.Remove(conn)
+					default:
+						_, _ = cm.Get(n % 10000)
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkConnManagerIterate(b *testing.B) {
+	for _, size := range []int{1000, 10000} {
+		for name, newCM := range testConnManagers() {
+			b.Run(name+"/"+strconv.Itoa(size), func(b *testing.B) {
+				cm := newCM()
+				fillConnManager(cm, size)
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						n := 0
+						cm.Range(func(ziface.IConnection) bool {
+							n++
+							return true
+						})
+					}
+				})
+			})
+		}
+	}
+}
+
+func TestConnManagerIndex(t *testing.T) {
+	for name, newCM := range testConnManagers() {
+		t.Run(name, func(t *testing.T) {
+			cm := newCM()
+			conns := make([]*Connection, 4)
+			for i := range conns {
+				conns[i] = &Connection{ConnID: uint32(i)}
+				cm.Add(conns[i])
+			}
+			conns[0].SetProperty("room", "lobby")
+
+			// 建立索引时已有的属性也会被索引
+			cm.AddIndex("room")
+			conns[1].SetProperty("room", "lobby")
+			conns[2].SetProperty("room", "arena")
+			if got := len(cm.GetByIndex("room", "lobby")); got != 2 {
+				t.Fatalf("lobby has %d conns, want 2", got)
+			}
+
+			conns[1].SetProperty("room", "arena")
+			conns[2].RemoveProperty("room")
+			cm.Remove(conns[0])
+			if got := len(cm.GetByIndex("room", "lobby")); got != 0 {
+				t.Fatalf("lobby has %d conns, want 0", got)
+			}
+			arena := cm.GetByIndex("room", "arena")
+			if len(arena) != 1 || arena[0].GetConnID() != 1 {
+				t.Fatalf("arena = %v", arena)
+			}
+
+			// 移除后的连接属性变化不再影响索引
+			conns[0].SetProperty("room", "arena")
+			if got := len(cm.GetByIndex("room", "arena")); got != 1 {
+				t.Fatalf("arena has %d conns, want 1", got)
+			}
+
+			odd := cm.Filter(func(conn ziface.IConnection) bool { return conn.GetConnID()%2 == 1 })
+			if len(odd) != 2 {
+				t.Fatalf("Filter got %d conns, want 2", len(odd))
+			}
+		})
+	}
+}
+
+func TestConnManagerIndexConcurrentUpdate(t *testing.T) {
+	for name, newCM := range testConnManagers() {
+		t.Run(name, func(t *testing.T) {
+			cm := newCM()
+			conn := &Connection{ConnID: 1}
+			cm.Add(conn)
+
+			// 先注册的监听让第一次修改的通知停在索引更新之前，直到第二次修改的通知全部完成
+			release := make(chan struct{})
+			conn.WatchProperty("room", func(_ ziface.IConnection, change ziface.PropertyChange) {
+				if change.New == "lobby" {
+					<-release
+				}
+			})
+			cm.AddIndex("room")
+
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				conn.SetProperty("room", "lobby")
+			}()
+			waitFor(t, "first update", func() bool {
+				value, _ := conn.GetProperty("room")
+				return value == "lobby"
+			})
+			conn.SetProperty("room", "arena")
+			close(release)
+			wg.Wait()
+
+			// 索引与属性的当前值一致，不会保留较早修改的值
+			if got := len(cm.GetByIndex("room", "lobby")); got != 0 {
+				t.Fatalf("lobby has %d conns, want 0", got)
+			}
+			if got := len(cm.GetByIndex("room", "arena")); got != 1 {
+				t.Fatalf("arena has %d conns, want 1", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package znet
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+// 连接的流量计数器，读写 Goroutine 和发送方并发更新
+type connStats struct {
+	bytesIn      atomic.Uint64
+	bytesOut     atomic.Uint64
+	msgsIn       atomic.Uint64
+	msgsOut      atomic.Uint64
+	droppedSends atomic.Uint64
+	// 最后一次读写的时间(UnixNano)
+	lastRead  atomic.Int64
+	lastWrite atomic.Int64
+	// 输出队列开始高于高水位的时间(UnixNano)，0 表示当前低于高水位
+	highWaterSince atomic.Int64
+	// 建立连接的时间，创建后不再修改
+	connectTime time.Time
+}
+
+// 记录读取到一条消息
+func (s *connStats) addRead(n int) {
+	s.bytesIn.Add(uint64(n))
+	s.msgsIn.Add(1)
+	s.lastRead.Store(time.Now().UnixNano())
+}
+
+// 记录写出一条消息
+func (s *connStats) addWrite(n int) {
+	s.bytesOut.Add(uint64(n))
+	s.msgsOut.Add(1)
+	s.lastWrite.Store(time.Now().UnixNano())
+}
+
+func unixNanoTime(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// 生成统计信息快照
+func (s *connStats) snapshot() ziface.ConnStats {
+	return ziface.ConnStats{
+		BytesIn:       s.bytesIn.Load(),
+		BytesOut:      s.bytesOut.Load(),
+		MsgsIn:        s.msgsIn.Load(),
+		MsgsOut:       s.msgsOut.Load(),
+		ConnectTime:   s.connectTime,
+		LastReadTime:  unixNanoTime(s.lastRead.Load()),
+		LastWriteTime: unixNanoTime(s.lastWrite.Load()),
+		DroppedSends:  s.droppedSends.Load(),
+	}
+}
@@ -0,0 +1,44 @@
+package znet
+
+import (
+	"bufio"
+	"testing"
+
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+func TestConnStats(t *testing.T) {
+	s := newTestServer(t)
+	s.AddRouter(1, &testEchoRouter{})
+	client, conn := dialTestServer(t, s)
+	r := bufio.NewReader(client)
+
+	// 两条消息各 8 字节头部，消息体共 5 字节
+	for _, data := range []string{"hi", "abc"} {
+		writeTestFrame(t, client, s.Codec(), 1, []byte(data))
+		if _, got := readTestFrame(t, client, r, s.Codec()); got != data {
+			t.Fatalf("echo = %q, want %q", got, data)
+		}
+	}
+	waitFor(t, "write stats", func() bool { return conn.Stats().MsgsOut == 2 })
+	stats := conn.Stats()
+	if stats.MsgsIn != 2 || stats.BytesIn != 21 || stats.BytesOut != 21 {
+		t.Fatalf("conn stats = %+v", stats)
+	}
+	if stats.LastReadTime.Before(stats.ConnectTime) || stats.LastWriteTime.Before(stats.LastReadTime) {
+		t.Fatalf("conn stats times = %+v", stats)
+	}
+
+	want := ziface.ConnMgrStats{Conns: 1, TotalConns: 1, BytesIn: 21, BytesOut: 21, MsgsIn: 2, MsgsOut: 2}
+	if got := s.GetConnMgr().Stats(); got != want {
+		t.Fatalf("server stats = %+v, want %+v", got, want)
+	}
+
+	// 连接关闭后流量仍计入汇总
+	_ = client.Close()
+	waitFor(t, "conn removed", func() bool { return s.GetConnMgr().Len() == 0 })
+	want.Conns = 0
+	if got := s.GetConnMgr().Stats(); got != want {
+		t.Fatalf("server stats after close = %+v, want %+v", got, want)
+	}
+}
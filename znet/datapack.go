@@ -1,7 +1,6 @@
 package znet
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
 
@@ -9,55 +8,39 @@ import (
 	"github.com/dokidokikoi/my-zinx/ziface"
 )
 
+// DataPack 的包头长度
+const dataPackHeadLen = 8
+
 type DataPack struct{}
 
 func (dp *DataPack) GetHeadLen() uint32 {
 	// ID uint32(4字节) + DataLen uint32(4字节)
-	return 8
+	return dataPackHeadLen
 }
 
 func (dp *DataPack) Pack(msg ziface.IMessage) ([]byte, error) {
-	// 创建一个存放 byte 的缓冲
-	dataBuff := bytes.NewBuffer([]byte{})
+	return dp.AppendPack(make([]byte, 0, dataPackHeadLen+len(msg.GetData())), msg)
+}
 
+// 将 msg 封包后追加到 dst 尾部，dst 容量足够时不会产生内存分配
+func (dp *DataPack) AppendPack(dst []byte, msg ziface.IMessage) ([]byte, error) {
 	// 写 dataLen
-	err := binary.Write(dataBuff, binary.LittleEndian, msg.GetDataLen())
-	if err != nil {
-		return nil, err
-	}
-
+	dst = binary.LittleEndian.AppendUint32(dst, msg.GetDataLen())
 	// 写 msgID
-	err = binary.Write(dataBuff, binary.LittleEndian, msg.GetMsgID())
-	if err != nil {
-		return nil, err
-	}
-
+	dst = binary.LittleEndian.AppendUint32(dst, msg.GetMsgID())
 	// 写 data 数据
-	err = binary.Write(dataBuff, binary.LittleEndian, msg.GetData())
-	if err != nil {
-		return nil, err
-	}
-
-	return dataBuff.Bytes(), nil
+	return append(dst, msg.GetData()...), nil
 }
 
 func (dp *DataPack) Unpack(binaryData []byte) (ziface.IMessage, error) {
-	// 创建一个输入二进制数据的 ioReader
-	dataBuff := bytes.NewBuffer(binaryData)
-
-	// 只解压 head 的信息，得到 dataLen 和 msgID
-	msg := &Message{}
-
-	// 读 dataLen
-	err := binary.Read(dataBuff, binary.LittleEndian, &msg.DataLen)
-	if err != nil {
-		return nil, err
+	if len(binaryData) < dataPackHeadLen {
+		return nil, errors.New("msg head data too short")
 	}
 
-	// 读 msgID
-	err = binary.Read(dataBuff, binary.LittleEndian, &msg.ID)
-	if err != nil {
-		return nil, err
+	// 只解压 head 的信息，得到 dataLen 和 msgID
+	msg := &Message{
+		DataLen: binary.LittleEndian.Uint32(binaryData[0:4]),
+		ID:      binary.LittleEndian.Uint32(binaryData[4:8]),
 	}
 
 	// 判断 dataLen 的长度是否超出允许的范围
@@ -0,0 +1,66 @@
+package znet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+func TestDataPackAppendPack(t *testing.T) {
+	dp := &DataPack{}
+	msg := NewMessage(3, []byte("zinx"))
+
+	packed, err := dp.Pack(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := []byte("prefix")
+	appended, err := dp.AppendPack(prefix, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(appended[len(prefix):], packed) {
+		t.Fatalf("AppendPack = %v, want %v", appended[len(prefix):], packed)
+	}
+
+	head, err := dp.Unpack(packed[:dp.GetHeadLen()])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.GetMsgID() != 3 || head.GetDataLen() != 4 {
+		t.Fatalf("Unpack got msgID=%d dataLen=%d", head.GetMsgID(), head.GetDataLen())
+	}
+	if _, err := dp.Unpack(packed[:4]); err == nil {
+		t.Fatal("Unpack short head should fail")
+	}
+}
+
+func BenchmarkDataPackPack(b *testing.B) {
+	dp := NewDataPack()
+	msg := NewMessage(1, make([]byte, 64))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = dp.Pack(msg)
+	}
+}
+
+func BenchmarkDataPackAppendPack(b *testing.B) {
+	dp := NewDataPack().(ziface.IAppendPacket)
+	msg := NewMessage(1, make([]byte, 64))
+	buf := make([]byte, 0, 128)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf, _ = dp.AppendPack(buf[:0], msg)
+	}
+}
+
+func BenchmarkDataPackUnpack(b *testing.B) {
+	dp := NewDataPack()
+	packed, _ := dp.Pack(NewMessage(1, make([]byte, 64)))
+	head := packed[:dp.GetHeadLen()]
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = dp.Unpack(head)
+	}
+}
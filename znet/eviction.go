@@ -0,0 +1,158 @@
+package znet
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dokidokikoi/my-zinx/utils"
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+// 连接数达到 MaxConn 时的处理策略
+const (
+	// 拒绝新连接
+	MaxConnReject = "reject"
+	// 踢掉空闲时间最长的连接
+	MaxConnEvictIdle = "evict_idle"
+	// 优先踢掉未绑定用户的连接中空闲时间最长的，全部连接都已绑定用户时按 evict_idle 处理
+	MaxConnEvictUnauth = "evict_unauth"
+)
+
+// 连接因连接数达到上限被踢掉时的关闭原因说明
+var ErrEvicted = errors.New("evicted for new connection")
+
+// 连接最后一次收到数据的时间，还没有收到过数据时为建立连接的时间
+func lastActiveTime(conn ziface.IConnection) time.Time {
+	stats := conn.Stats()
+	if stats.LastReadTime.After(stats.ConnectTime) {
+		return stats.LastReadTime
+	}
+	return stats.ConnectTime
+}
+
+// 记录选出被踢掉的连接所需的连接状态，
+// 连接数达到上限时遍历记录的连接选出空闲最久的，不必遍历连接管理中的全部连接再逐个检查是否绑定用户
+type evictionTracker struct {
+	lock sync.Mutex
+	// 未关闭的连接
+	conns map[uint32]ziface.IConnection
+	// 其中未绑定用户的连接
+	unauth map[uint32]ziface.IConnection
+	// 已经开始关闭但可能还没有从连接管理中移除的连接
+	closing map[uint32]ziface.IConnection
+}
+
+func newEvictionTracker() *evictionTracker {
+	return &evictionTracker{
+		conns:   make(map[uint32]ziface.IConnection),
+		unauth:  make(map[uint32]ziface.IConnection),
+		closing: make(map[uint32]ziface.IConnection),
+	}
+}
+
+// 新连接加入连接管理后记录
+func (et *evictionTracker) add(conn ziface.IConnection) {
+	et.lock.Lock()
+	defer et.lock.Unlock()
+
+	et.conns[conn.GetConnID()] = conn
+	et.unauth[conn.GetConnID()] = conn
+}
+
+// 连接开始关闭
+func (et *evictionTracker) remove(conn ziface.IConnection) {
+	et.lock.Lock()
+	defer et.lock.Unlock()
+
+	if et.conns[conn.GetConnID()] != conn {
+		return
+	}
+	delete(et.conns, conn.GetConnID())
+	delete(et.unauth, conn.GetConnID())
+	et.closing[conn.GetConnID()] = conn
+}
+
+func (et *evictionTracker) sessionBound(user string, conn ziface.IConnection) {
+	et.lock.Lock()
+	defer et.lock.Unlock()
+
+	delete(et.unauth, conn.GetConnID())
+}
+
+func (et *evictionTracker) sessionUnbound(user string, conn ziface.IConnection) {
+	et.lock.Lock()
+	defer et.lock.Unlock()
+
+	// 连接关闭时的解除绑定不再记录
+	if et.conns[conn.GetConnID()] == conn && !connClosing(conn) {
+		et.unauth[conn.GetConnID()] = conn
+	}
+}
+
+// 返回已经开始关闭但还在连接管理中的连接数，同时清除已经移除的连接
+func (et *evictionTracker) pending(connMgr ziface.IConnManager) int {
+	et.lock.Lock()
+	defer et.lock.Unlock()
+
+	for connID, conn := range et.closing {
+		if c, err := connMgr.Get(connID); err != nil || c != conn {
+			delete(et.closing, connID)
+		}
+	}
+	return len(et.closing)
+}
+
+// 选出空闲最久的连接，unauth 为 true 时只在未绑定用户的连接中选择
+func (et *evictionTracker) idlest(unauth bool) ziface.IConnection {
+	et.lock.Lock()
+	defer et.lock.Unlock()
+
+	conns := et.conns
+	if unauth {
+		conns = et.unauth
+	}
+	var victim ziface.IConnection
+	var victimActive time.Time
+	for _, conn := range conns {
+		// 正在关闭的连接很快会从连接管理中移除，不再重复踢掉
+		if connClosing(conn) {
+			continue
+		}
+		if active := lastActiveTime(conn); victim == nil || active.Before(victimActive) {
+			victim, victimActive = conn, active
+		}
+	}
+	return victim
+}
+
+// 按 MaxConnPolicy 选出要被踢掉的连接，返回 nil 表示没有可以踢掉的连接
+func (s *Server) evictCandidate() ziface.IConnection {
+	switch utils.GlobalObject.MaxConnPolicy {
+	case MaxConnEvictIdle:
+		return s.evictions.idlest(false)
+	case MaxConnEvictUnauth:
+		if victim := s.evictions.idlest(true); victim != nil {
+			return victim
+		}
+		return s.evictions.idlest(false)
+	default:
+		return nil
+	}
+}
+
+// 连接数达到上限时为新连接腾出位置，返回 false 表示应拒绝新连接
+func (s *Server) evictForNewConn() bool {
+	// 之前关闭的连接还没有移除完成，除去这些连接后仍有空位
+	if closing := s.evictions.pending(s.ConnMgr); closing > 0 && s.ConnMgr.Len()-closing < utils.GlobalObject.MaxConn {
+		return true
+	}
+	victim := s.evictCandidate()
+	if victim == nil {
+		return false
+	}
+	s.CallOnConnEvict(victim)
+	victim.StopWithReason(ziface.CloseEvicted, ErrEvicted)
+	s.evictions.remove(victim)
+	return true
+}
@@ -0,0 +1,110 @@
+package znet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dokidokikoi/my-zinx/utils"
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+func TestEvictCandidate(t *testing.T) {
+	oldPolicy := utils.GlobalObject.MaxConnPolicy
+	defer func() { utils.GlobalObject.MaxConnPolicy = oldPolicy }()
+
+	sessionMgr := NewSessionManager()
+	s := &Server{ConnMgr: NewShardedConnManager(4), SessionMgr: sessionMgr, evictions: newEvictionTracker()}
+	sessionMgr.addListener(s.evictions)
+	now := time.Now()
+	conns := make([]*Connection, 3)
+	for i := range conns {
+		conns[i] = newTestConnection(uint32(i))
+		conns[i].stats.connectTime = now.Add(-time.Minute)
+		s.ConnMgr.Add(conns[i])
+		s.evictions.add(conns[i])
+	}
+	// conn 0 空闲最久，conn 1 刚收到过数据，conn 2 从未收到数据
+	conns[0].stats.lastRead.Store(now.Add(-50 * time.Second).UnixNano())
+	conns[1].stats.lastRead.Store(now.UnixNano())
+	conns[2].stats.connectTime = now.Add(-10 * time.Second)
+
+	utils.GlobalObject.MaxConnPolicy = MaxConnReject
+	if victim := s.evictCandidate(); victim != nil {
+		t.Fatalf("reject policy evicted conn %d", victim.GetConnID())
+	}
+
+	utils.GlobalObject.MaxConnPolicy = MaxConnEvictIdle
+	if victim := s.evictCandidate(); victim != conns[0] {
+		t.Fatalf("evict_idle chose %v, want conn 0", victim)
+	}
+
+	utils.GlobalObject.MaxConnPolicy = MaxConnEvictUnauth
+	if err := s.SessionMgr.Bind("alice", conns[0]); err != nil {
+		t.Fatal(err)
+	}
+	if victim := s.evictCandidate(); victim != conns[2] {
+		t.Fatalf("evict_unauth chose %v, want conn 2", victim)
+	}
+
+	// 被踢掉的连接正在关闭，不再被选中
+	var evicted []ziface.IConnection
+	s.SetOnConnEvict(func(conn ziface.IConnection) { evicted = append(evicted, conn) })
+	if !s.evictForNewConn() || conns[2].CloseReason().Code != ziface.CloseEvicted {
+		t.Fatalf("conn 2 close reason = %v", conns[2].CloseReason())
+	}
+	if len(evicted) != 1 || evicted[0] != conns[2] {
+		t.Fatalf("evict hook got %v", evicted)
+	}
+	if victim := s.evictCandidate(); victim != conns[1] {
+		t.Fatalf("evict_unauth chose %v after eviction, want conn 1", victim)
+	}
+
+	// 全部连接都已绑定用户时踢掉空闲最久的连接
+	if err := s.SessionMgr.Bind("bob", conns[1]); err != nil {
+		t.Fatal(err)
+	}
+	if victim := s.evictCandidate(); victim != conns[0] {
+		t.Fatalf("evict_unauth with all bound chose %v, want conn 0", victim)
+	}
+	// 解除绑定后重新优先踢掉
+	s.SessionMgr.Unbind(conns[1])
+	if victim := s.evictCandidate(); victim != conns[1] {
+		t.Fatalf("evict_unauth after unbind chose %v, want conn 1", victim)
+	}
+
+	// 除去正在关闭的连接后未达到上限时，不再踢掉其他连接
+	oldMaxConn := utils.GlobalObject.MaxConn
+	defer func() { utils.GlobalObject.MaxConn = oldMaxConn }()
+	utils.GlobalObject.MaxConn = 3
+	if !s.evictForNewConn() || len(evicted) != 1 {
+		t.Fatalf("evicted %d conns, want 1", len(evicted))
+	}
+	// 从连接管理中移除后不再计入
+	s.ConnMgr.Remove(conns[2])
+	if n := s.evictions.pending(s.ConnMgr); n != 0 {
+		t.Fatalf("pending closing conns = %d, want 0", n)
+	}
+}
+
+func TestEvictCandidateIdlest(t *testing.T) {
+	oldPolicy := utils.GlobalObject.MaxConnPolicy
+	defer func() { utils.GlobalObject.MaxConnPolicy = oldPolicy }()
+	utils.GlobalObject.MaxConnPolicy = MaxConnEvictIdle
+
+	s := &Server{ConnMgr: NewConnManager(), SessionMgr: NewSessionManager(), evictions: newEvictionTracker()}
+	now := time.Now()
+	conns := make([]*Connection, 1000)
+	for i := range conns {
+		conns[i] = newTestConnection(uint32(i))
+		conns[i].stats.connectTime = now.Add(-time.Duration(i) * time.Second)
+		s.ConnMgr.Add(conns[i])
+		s.evictions.add(conns[i])
+	}
+	// 每次都选出全部连接中空闲最久的
+	for i := len(conns) - 1; i > len(conns)-20; i-- {
+		if victim := s.evictCandidate(); victim != conns[i] {
+			t.Fatalf("victim = %d, want %d", victim.GetConnID(), i)
+		}
+		s.evictions.remove(conns[i])
+	}
+}
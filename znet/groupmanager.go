@@ -0,0 +1,200 @@
+package znet
+
+import (
+	"sync"
+
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+// IGroupManager 的实现
+type GroupManager struct {
+	// 分组名 -> ConnID -> 连接
+	groups map[string]map[uint32]ziface.IConnection
+	// ConnID -> 连接所在的分组
+	connGroups map[uint32]map[string]struct{}
+	// 保护分组信息的读写锁
+	lock sync.RWMutex
+	// 广播时使用的编解码器
+	codec ziface.IFrameCodec
+
+	onJoin  func(group string, conn ziface.IConnection)
+	onLeave func(group string, conn ziface.IConnection)
+	onEmpty func(group string)
+}
+
+func (gm *GroupManager) Join(group string, conn ziface.IConnection) error {
+	gm.lock.Lock()
+	// 在锁内检查，保证连接关闭时的 LeaveAll 一定发生在加入之后
+	if connClosing(conn) {
+		gm.lock.Unlock()
+		return ErrConnClosed
+	}
+	members, ok := gm.groups[group]
+	if !ok {
+		members = make(map[uint32]ziface.IConnection)
+		gm.groups[group] = members
+	}
+	if _, ok := members[conn.GetConnID()]; ok {
+		gm.lock.Unlock()
+		return nil
+	}
+	members[conn.GetConnID()] = conn
+	if gm.connGroups[conn.GetConnID()] == nil {
+		gm.connGroups[conn.GetConnID()] = make(map[string]struct{})
+	}
+	gm.connGroups[conn.GetConnID()][group] = struct{}{}
+	gm.lock.Unlock()
+
+	if gm.onJoin != nil {
+		gm.onJoin(group, conn)
+	}
+	return nil
+}
+
+func (gm *GroupManager) Leave(group string, conn ziface.IConnection) {
+	gm.lock.Lock()
+	left, empty := gm.leave(group, conn.GetConnID())
+	gm.lock.Unlock()
+
+	if left {
+		gm.callOnLeave(group, conn, empty)
+	}
+}
+
+func (gm *GroupManager) LeaveAll(conn ziface.IConnection) {
+	gm.lock.Lock()
+	groups := make(map[string]bool, len(gm.connGroups[conn.GetConnID()]))
+	for group := range gm.connGroups[conn.GetConnID()] {
+		_, groups[group] = gm.leave(group, conn.GetConnID())
+	}
+	gm.lock.Unlock()
+
+	for group, empty := range groups {
+		gm.callOnLeave(group, conn, empty)
+	}
+}
+
+// 将连接移出分组，返回是否移出以及分组是否变为空，调用方需持有写锁
+func (gm *GroupManager) leave(group string, connID uint32) (bool, bool) {
+	members, ok := gm.groups[group]
+	if !ok {
+		return false, false
+	}
+	if _, ok := members[connID]; !ok {
+		return false, false
+	}
+
+	delete(members, connID)
+	delete(gm.connGroups[connID], group)
+	if len(gm.connGroups[connID]) == 0 {
+		delete(gm.connGroups, connID)
+	}
+	if len(members) > 0 {
+		return true, false
+	}
+	delete(gm.groups, group)
+	return true, true
+}
+
+func (gm *GroupManager) callOnLeave(group string, conn ziface.IConnection, empty bool) {
+	if gm.onLeave != nil {
+		gm.onLeave(group, conn)
+	}
+	if empty && gm.onEmpty != nil {
+		gm.onEmpty(group)
+	}
+}
+
+func (gm *GroupManager) Members(group string) []ziface.IConnection {
+	gm.lock.RLock()
+	defer gm.lock.RUnlock()
+
+	conns := make([]ziface.IConnection, 0, len(gm.groups[group]))
+	for _, conn := range gm.groups[group] {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+func (gm *GroupManager) Count(group string) int {
+	gm.lock.RLock()
+	defer gm.lock.RUnlock()
+
+	return len(gm.groups[group])
+}
+
+func (gm *GroupManager) IsMember(group string, conn ziface.IConnection) bool {
+	gm.lock.RLock()
+	defer gm.lock.RUnlock()
+
+	_, ok := gm.groups[group][conn.GetConnID()]
+	return ok
+}
+
+func (gm *GroupManager) Groups() []string {
+	gm.lock.RLock()
+	defer gm.lock.RUnlock()
+
+	groups := make([]string, 0, len(gm.groups))
+	for group := range gm.groups {
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+func (gm *GroupManager) GroupsOf(conn ziface.IConnection) []string {
+	gm.lock.RLock()
+	defer gm.lock.RUnlock()
+
+	groups := make([]string, 0, len(gm.connGroups[conn.GetConnID()]))
+	for group := range gm.connGroups[conn.GetConnID()] {
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+func (gm *GroupManager) Broadcast(group string, msgID uint32, data []byte, exclude ...uint32) []ziface.SendResult {
+	gm.lock.RLock()
+	conns := make([]ziface.IConnection, 0, len(gm.groups[group]))
+	for connID, conn := range gm.groups[group] {
+		if !containsConnID(exclude, connID) {
+			conns = append(conns, conn)
+		}
+	}
+	gm.lock.RUnlock()
+
+	return broadcastPacked(gm.codec, conns, msgID, data)
+}
+
+func containsConnID(connIDs []uint32, connID uint32) bool {
+	for _, id := range connIDs {
+		if id == connID {
+			return true
+		}
+	}
+	return false
+}
+
+func (gm *GroupManager) SetCodec(codec ziface.IFrameCodec) {
+	gm.codec = codec
+}
+
+func (gm *GroupManager) SetOnJoin(hookFunc func(group string, conn ziface.IConnection)) {
+	gm.onJoin = hookFunc
+}
+
+func (gm *GroupManager) SetOnLeave(hookFunc func(group string, conn ziface.IConnection)) {
+	gm.onLeave = hookFunc
+}
+
+func (gm *GroupManager) SetOnEmpty(hookFunc func(group string)) {
+	gm.onEmpty = hookFunc
+}
+
+func NewGroupManager() *GroupManager {
+	return &GroupManager{
+		groups:     make(map[string]map[uint32]ziface.IConnection),
+		connGroups: make(map[uint32]map[string]struct{}),
+		codec:      NewPacketCodec(NewDataPack()),
+	}
+}
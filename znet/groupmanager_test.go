@@ -0,0 +1,35 @@
+package znet
+
+import (
+	"testing"
+
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+func TestGroupManager(t *testing.T) {
+	gm := NewGroupManager()
+	var joined, left int
+	var emptied []string
+	gm.SetOnJoin(func(string, ziface.IConnection) { joined++ })
+	gm.SetOnLeave(func(string, ziface.IConnection) { left++ })
+	gm.SetOnEmpty(func(group string) { emptied = append(emptied, group) })
+
+	c1, c2 := &Connection{ConnID: 1}, &Connection{ConnID: 2}
+	_ = gm.Join("room", c1)
+	_ = gm.Join("room", c2)
+	_ = gm.Join("room", c2)
+	_ = gm.Join("world", c1)
+	if joined != 3 || gm.Count("room") != 2 || !gm.IsMember("world", c1) {
+		t.Fatalf("joined = %d, room count = %d", joined, gm.Count("room"))
+	}
+
+	gm.Leave("room", c2)
+	if gm.Count("room") != 1 || len(gm.GroupsOf(c2)) != 0 {
+		t.Fatalf("room count = %d after leave", gm.Count("room"))
+	}
+
+	gm.LeaveAll(c1)
+	if left != 3 || len(emptied) != 2 || len(gm.Groups()) != 0 {
+		t.Fatalf("left = %d, emptied = %v, groups = %v", left, emptied, gm.Groups())
+	}
+}
@@ -0,0 +1,194 @@
+package znet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/dokidokikoi/my-zinx/utils"
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+// 长度字段拆包器的配置
+type LengthFieldConfig struct {
+	// 长度字段在帧中的偏移
+	LengthOffset int
+	// 长度字段的字节数，可以为 1、2、4、8
+	LengthWidth int
+	// 长度字段的值是否包含头部，为 false 时只表示头部之后消息体的长度
+	LengthIncludesHeader bool
+	// 长度字段的值加上该值后为消息体的长度，用于长度字段还包含头部中部分字段的协议，
+	// 如 Modbus/TCP 的长度包含之后的单元标识和功能码，将功能码作为 MsgID、头部取到功能码时为 -2
+	LengthAdjustment int
+	// MsgID 字段在帧中的偏移
+	MsgIDOffset int
+	// MsgID 字段的字节数，可以为 0、1、2、4，为 0 时所有消息都使用 DefaultMsgID
+	MsgIDWidth   int
+	DefaultMsgID uint32
+	// 长度字段和 MsgID 字段的字节序，为 nil 时使用小端序
+	ByteOrder binary.ByteOrder
+	// 头部的长度，之后的字节为消息体
+	// 不能小于长度字段和 MsgID 字段结束的位置，为 0 时头部到这两个字段结束
+	HeaderLength int
+	// 交给路由前从帧开头丢弃的字节数，不能大于头部长度，头部中剩余的字节放在消息体前面交给路由
+	// 为 0 时丢弃整个头部，为 LengthFieldStripNone 时整个头部都交给路由
+	// 封包时消息体中不包含头部
+	StripBytes int
+}
+
+// 不丢弃头部，路由收到完整的帧
+const LengthFieldStripNone = -1
+
+// 可配置头部格式的 IPacket 实现，用于对接已有设备或旧客户端的协议
+// 头部中长度字段和 MsgID 字段之外的字节封包时写 0，拆包时忽略
+type LengthFieldPacket struct {
+	conf    LengthFieldConfig
+	headLen int
+	// 拆包时丢弃的头部字节数
+	strip int
+}
+
+func validFieldWidth(width int, allowed ...int) bool {
+	for _, w := range allowed {
+		if width == w {
+			return true
+		}
+	}
+	return false
+}
+
+// 检查配置并创建长度字段拆包器
+func NewLengthFieldPacket(conf LengthFieldConfig) (*LengthFieldPacket, error) {
+	if !validFieldWidth(conf.LengthWidth, 1, 2, 4, 8) {
+		return nil, fmt.Errorf("invalid length field width %d", conf.LengthWidth)
+	}
+	if !validFieldWidth(conf.MsgIDWidth, 0, 1, 2, 4) {
+		return nil, fmt.Errorf("invalid msgID field width %d", conf.MsgIDWidth)
+	}
+	if conf.LengthOffset < 0 || conf.MsgIDOffset < 0 || conf.HeaderLength < 0 {
+		return nil, errors.New("negative length field offset")
+	}
+
+	lengthEnd := conf.LengthOffset + conf.LengthWidth
+	msgIDEnd := conf.MsgIDOffset + conf.MsgIDWidth
+	if conf.MsgIDWidth > 0 && conf.MsgIDOffset < lengthEnd && conf.LengthOffset < msgIDEnd {
+		return nil, errors.New("length field overlaps msgID field")
+	}
+	headLen := lengthEnd
+	if msgIDEnd > headLen {
+		headLen = msgIDEnd
+	}
+	if conf.HeaderLength > 0 {
+		if conf.HeaderLength < headLen {
+			return nil, fmt.Errorf("header length %d shorter than header fields %d", conf.HeaderLength, headLen)
+		}
+		headLen = conf.HeaderLength
+	}
+
+	strip := conf.StripBytes
+	switch {
+	case strip == 0:
+		strip = headLen
+	case strip == LengthFieldStripNone:
+		strip = 0
+	case strip < 0 || strip > headLen:
+		return nil, fmt.Errorf("strip bytes %d out of header length %d", conf.StripBytes, headLen)
+	}
+	if conf.ByteOrder == nil {
+		conf.ByteOrder = binary.LittleEndian
+	}
+	return &LengthFieldPacket{conf: conf, headLen: headLen, strip: strip}, nil
+}
+
+func (lp *LengthFieldPacket) GetHeadLen() uint32 {
+	return uint32(lp.headLen)
+}
+
+func (lp *LengthFieldPacket) Pack(msg ziface.IMessage) ([]byte, error) {
+	return lp.AppendPack(make([]byte, 0, lp.headLen+len(msg.GetData())), msg)
+}
+
+func (lp *LengthFieldPacket) AppendPack(dst []byte, msg ziface.IMessage) ([]byte, error) {
+	length := int64(len(msg.GetData())) - int64(lp.conf.LengthAdjustment)
+	if lp.conf.LengthIncludesHeader {
+		length += int64(lp.headLen)
+	}
+	if length < 0 || (lp.conf.LengthWidth < 8 && length >= 1<<(8*lp.conf.LengthWidth)) {
+		return nil, fmt.Errorf("msg length %d overflows %d byte length field", length, lp.conf.LengthWidth)
+	}
+	if lp.conf.MsgIDWidth > 0 && lp.conf.MsgIDWidth < 4 && msg.GetMsgID() >= 1<<(8*lp.conf.MsgIDWidth) {
+		return nil, fmt.Errorf("msgID %d overflows %d byte msgID field", msg.GetMsgID(), lp.conf.MsgIDWidth)
+	}
+
+	start := len(dst)
+	dst = append(dst, make([]byte, lp.headLen)...)
+	head := dst[start:]
+	putField(lp.conf.ByteOrder, head[lp.conf.LengthOffset:], lp.conf.LengthWidth, uint64(length))
+	if lp.conf.MsgIDWidth > 0 {
+		putField(lp.conf.ByteOrder, head[lp.conf.MsgIDOffset:], lp.conf.MsgIDWidth, uint64(msg.GetMsgID()))
+	}
+	return append(dst, msg.GetData()...), nil
+}
+
+func (lp *LengthFieldPacket) Unpack(binaryData []byte) (ziface.IMessage, error) {
+	if len(binaryData) < lp.headLen {
+		return nil, errors.New("msg head data too short")
+	}
+
+	field := getField(lp.conf.ByteOrder, binaryData[lp.conf.LengthOffset:], lp.conf.LengthWidth)
+	if field > math.MaxInt64 {
+		return nil, errors.New("Too Large msg data received")
+	}
+	length := int64(field) + int64(lp.conf.LengthAdjustment)
+	if lp.conf.LengthIncludesHeader {
+		length -= int64(lp.headLen)
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("frame length %d shorter than header", field)
+	}
+	if length > int64(^uint32(0)) ||
+		(utils.GlobalObject.MaxPacketSize > 0 && length > int64(utils.GlobalObject.MaxPacketSize)) {
+		return nil, errors.New("Too Large msg data received")
+	}
+
+	msg := &Message{DataLen: uint32(length), ID: lp.conf.DefaultMsgID}
+	if lp.conf.MsgIDWidth > 0 {
+		msg.ID = uint32(getField(lp.conf.ByteOrder, binaryData[lp.conf.MsgIDOffset:], lp.conf.MsgIDWidth))
+	}
+	return msg, nil
+}
+
+// 拆包时交给路由的头部字节，PacketCodec 将其放在消息体前面
+func (lp *LengthFieldPacket) keptHead(head []byte) []byte {
+	return head[lp.strip:lp.headLen]
+}
+
+// 按字节序将 value 写入 width 字节的字段
+func putField(order binary.ByteOrder, b []byte, width int, value uint64) {
+	switch width {
+	case 1:
+		b[0] = byte(value)
+	case 2:
+		order.PutUint16(b, uint16(value))
+	case 4:
+		order.PutUint32(b, uint32(value))
+	case 8:
+		order.PutUint64(b, value)
+	}
+}
+
+// 按字节序读取 width 字节的字段
+func getField(order binary.ByteOrder, b []byte, width int) uint64 {
+	switch width {
+	case 1:
+		return uint64(b[0])
+	case 2:
+		return uint64(order.Uint16(b))
+	case 4:
+		return uint64(order.Uint32(b))
+	case 8:
+		return order.Uint64(b)
+	}
+	return 0
+}
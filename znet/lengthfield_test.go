@@ -0,0 +1,166 @@
+package znet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/dokidokikoi/my-zinx/utils"
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+func TestLengthFieldPacket(t *testing.T) {
+	// 1 字节 MsgID + 1 字节保留 + 2 字节大端序总长度 + 2 字节校验(丢弃)
+	lp, err := NewLengthFieldPacket(LengthFieldConfig{
+		LengthOffset:         2,
+		LengthWidth:          2,
+		LengthIncludesHeader: true,
+		MsgIDOffset:          0,
+		MsgIDWidth:           1,
+		ByteOrder:            binary.BigEndian,
+		HeaderLength:         6,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lp.GetHeadLen() != 6 {
+		t.Fatalf("GetHeadLen = %d, want 6", lp.GetHeadLen())
+	}
+
+	packed, err := lp.Pack(NewMessage(7, []byte("ping")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{7, 0, 0, 10, 0, 0, 'p', 'i', 'n', 'g'}
+	if !bytes.Equal(packed, want) {
+		t.Fatalf("Pack = %v, want %v", packed, want)
+	}
+	msg, err := lp.Unpack(packed)
+	if err != nil || msg.GetMsgID() != 7 || msg.GetDataLen() != 4 {
+		t.Fatalf("Unpack = %+v, %v", msg, err)
+	}
+
+	if _, err := lp.Pack(NewMessage(256, nil)); err == nil {
+		t.Fatal("Pack msgID overflowing 1 byte should fail")
+	}
+	if _, err := lp.Unpack([]byte{7, 0, 0, 3, 0, 0}); err == nil {
+		t.Fatal("Unpack length shorter than header should fail")
+	}
+
+	oldMax := utils.GlobalObject.MaxPacketSize
+	defer func() { utils.GlobalObject.MaxPacketSize = oldMax }()
+	utils.GlobalObject.MaxPacketSize = 100
+	if _, err := lp.Unpack([]byte{7, 0, 0x10, 0, 0, 0}); err == nil {
+		t.Fatal("Unpack over MaxPacketSize should fail")
+	}
+}
+
+func TestLengthFieldPacketDefaultMsgID(t *testing.T) {
+	// 只有 8 字节长度字段，所有消息都交给 MsgID 9
+	lp, err := NewLengthFieldPacket(LengthFieldConfig{LengthWidth: 8, DefaultMsgID: 9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	packed, _ := lp.Pack(NewMessage(9, []byte("abc")))
+	if !bytes.Equal(packed, []byte{3, 0, 0, 0, 0, 0, 0, 0, 'a', 'b', 'c'}) {
+		t.Fatalf("Pack = %v", packed)
+	}
+	msg, err := lp.Unpack(packed)
+	if err != nil || msg.GetMsgID() != 9 || msg.GetDataLen() != 3 {
+		t.Fatalf("Unpack = %+v, %v", msg, err)
+	}
+}
+
+func TestLengthFieldConfigInvalid(t *testing.T) {
+	for name, conf := range map[string]LengthFieldConfig{
+		"length width": {LengthWidth: 3},
+		"msgID width":  {LengthWidth: 4, MsgIDWidth: 8},
+		"overlap":      {LengthWidth: 4, MsgIDOffset: 2, MsgIDWidth: 4},
+		"header":       {LengthWidth: 4, MsgIDOffset: 4, MsgIDWidth: 4, HeaderLength: 6},
+		"strip":        {LengthWidth: 4, HeaderLength: 6, StripBytes: 7},
+	} {
+		if _, err := NewLengthFieldPacket(conf); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+// Modbus/TCP: 事务标识(2) + 协议标识(2) + 长度(2, 大端序) + 单元标识(1) + 功能码(1) + 数据
+// 长度包含单元标识和功能码，功能码作为 MsgID，单元标识和功能码交给路由
+func newModbusPacket(t *testing.T) *LengthFieldPacket {
+	t.Helper()
+	lp, err := NewLengthFieldPacket(LengthFieldConfig{
+		LengthOffset:     4,
+		LengthWidth:      2,
+		LengthAdjustment: -2,
+		MsgIDOffset:      7,
+		MsgIDWidth:       1,
+		ByteOrder:        binary.BigEndian,
+		HeaderLength:     8,
+		StripBytes:       6,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lp
+}
+
+// 记录收到的消息体并回复固定的数据
+type testModbusRouter struct {
+	BaseRouter
+	received chan []byte
+}
+
+func (r *testModbusRouter) Handle(request ziface.IRequest) {
+	r.received <- append([]byte(nil), request.GetData()...)
+	_ = request.GetConnection().SendMsg(request.GetMsgID(), []byte{6, 0, 0x2b, 0, 0, 0, 0x64})
+}
+
+func TestLengthFieldPacketServer(t *testing.T) {
+	router := &testModbusRouter{received: make(chan []byte, 1)}
+	s := newTestServer(t, WithPacket(newModbusPacket(t)))
+	s.AddRouter(3, router)
+
+	// 读取保持寄存器的请求
+	client, _ := dialTestServer(t, s)
+	request := []byte{0, 1, 0, 0, 0, 6, 0x11, 3, 0, 0x6b, 0, 3}
+	if _, err := client.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case data := <-router.received:
+		if !bytes.Equal(data, request[6:]) {
+			t.Fatalf("router got % x, want % x", data, request[6:])
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("router not called")
+	}
+
+	want := []byte{0, 0, 0, 0, 0, 9, 0, 3, 6, 0, 0x2b, 0, 0, 0, 0x64}
+	reply := make([]byte, len(want))
+	_ = client.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := io.ReadFull(bufio.NewReader(client), reply); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reply, want) {
+		t.Fatalf("reply % x, want % x", reply, want)
+	}
+}
+
+func TestLengthFieldPacketStripNone(t *testing.T) {
+	lp, err := NewLengthFieldPacket(LengthFieldConfig{LengthWidth: 2, MsgIDOffset: 2, MsgIDWidth: 1, StripBytes: LengthFieldStripNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	packed, _ := lp.Pack(NewMessage(5, []byte("ab")))
+	msg, err := NewPacketCodec(lp).Decode(bufio.NewReader(bytes.NewReader(packed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.GetMsgID() != 5 || !bytes.Equal(msg.GetData(), packed) || msg.GetDataLen() != uint32(len(packed)) {
+		t.Fatalf("Decode = %d % x, want the whole frame % x", msg.GetMsgID(), msg.GetData(), packed)
+	}
+}
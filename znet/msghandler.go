@@ -5,6 +5,7 @@ import (
 	"github.com/dokidokikoi/my-zinx/utils"
 	"github.com/dokidokikoi/my-zinx/ziface"
 	"strconv"
+	"sync"
 )
 
 type MsgHandler struct {
@@ -13,9 +14,19 @@ type MsgHandler struct {
 	WorkerPoolSize uint32
 	// Worker 负责任务的消息队列
 	TaskQueue []chan ziface.IRequest
+	// 关闭时通知 worker 退出
+	quit     chan struct{}
+	quitOnce sync.Once
+	workers  sync.WaitGroup
 }
 
 func (mh *MsgHandler) DoMsgHandler(request ziface.IRequest) {
+	// 处理完成后归还请求占用的缓冲
+	defer request.Release()
+	if req, ok := request.(dispatchedRequest); ok {
+		req.startDispatch()
+	}
+
 	handler, ok := mh.Apis[request.GetMsgID()]
 	if !ok {
 		fmt.Printf("api msgID=%d is not FOUND!\n", request.GetMsgID())
@@ -46,6 +57,8 @@ func (mh *MsgHandler) StartOneWorker(workerID int, taskQueue chan ziface.IReques
 		// 如果有消息，则取出队列的 Request，并执行绑定的业务方法
 		case req := <-taskQueue:
 			mh.DoMsgHandler(req)
+		case <-mh.quit:
+			return
 		}
 	}
 }
@@ -55,10 +68,20 @@ func (mh *MsgHandler) StartWorkerPool() {
 	for i := 0; i < int(mh.WorkerPoolSize); i++ {
 		mh.TaskQueue[i] = make(chan ziface.IRequest, utils.GlobalObject.MaxWorkerTaskLen)
 		// 启动当前 worker，阻塞的等待对应消息队列是否有消息传递进来
-		go mh.StartOneWorker(i, mh.TaskQueue[i])
+		mh.workers.Add(1)
+		go func(i int) {
+			defer mh.workers.Done()
+			mh.StartOneWorker(i, mh.TaskQueue[i])
+		}(i)
 	}
 }
 
+// 停止全部 worker，等待正在处理的请求处理完成，队列中剩余的请求不再处理
+func (mh *MsgHandler) StopWorkerPool() {
+	mh.quitOnce.Do(func() { close(mh.quit) })
+	mh.workers.Wait()
+}
+
 // 根据 ConnID 来分配当前的连接应该由哪个 worker 负责处理
 // 轮询的平均分配法则
 func (mh *MsgHandler) SendMsg2TaskQueue(request ziface.IRequest) {
@@ -76,5 +99,6 @@ func NewMsgHandler() *MsgHandler {
 		Apis:           make(map[uint32]ziface.IRouter),
 		WorkerPoolSize: utils.GlobalObject.WorkerPoolSize,
 		TaskQueue:      make([]chan ziface.IRequest, utils.GlobalObject.WorkerPoolSize),
+		quit:           make(chan struct{}),
 	}
 }
@@ -1,12 +1,70 @@
 package znet
 
-import "github.com/dokidokikoi/my-zinx/ziface"
+import (
+	"net"
+
+	"github.com/dokidokikoi/my-zinx/utils"
+
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
 
 type Option func(s *Server)
 
 // 只要实现Packet 接口可自由实现数据包解析格式，如果没有则使用默认解析格式
 func WithPacket(pack ziface.IPacket) Option {
 	return func(s *Server) {
-		s.packet = pack
+		s.codec = NewPacketCodec(pack)
+	}
+}
+
+// 使用自定义的编解码器，可以实现变长头部、分隔符等 IPacket 无法表达的格式
+func WithCodec(codec ziface.IFrameCodec) Option {
+	return func(s *Server) {
+		s.codec = codec
+	}
+}
+
+// 使用自定义的离线消息存储，同时开启离线消息
+func WithOutboxStore(store ziface.IOutboxStore) Option {
+	return func(s *Server) {
+		s.outboxStore = store
+	}
+}
+
+// 开启会话恢复时将连接属性同步到自定义的会话存储，未开启 ResumeEnable 时不使用该存储
+// 使用 FileSessionStore 时，自定义类型的属性值需要先调用 gob.Register 注册，未注册的属性不会被保存
+func WithSessionStore(store ziface.ISessionStore) Option {
+	return func(s *Server) {
+		s.sessionStore = store
+	}
+}
+
+// 开启集群，覆盖配置文件中的集群配置，便于在同一进程中启动多个节点
+// secret 为节点之间握手认证使用的共享密钥，所有节点必须相同
+func WithCluster(nodeID uint32, host string, port int, peers []utils.ClusterPeer, secret string) Option {
+	return func(s *Server) {
+		s.clusterConf = &clusterConfig{nodeID: nodeID, host: host, port: port, peers: peers, secret: secret}
+	}
+}
+
+// 连接工厂，返回的自定义连接类型必须嵌入 NewBaseConnection 创建的 *Connection，
+// 由 Server 负责添加到连接管理器
+type ConnFactory func(server ziface.IServer, conn *net.TCPConn, connID uint32, msgHandler ziface.IMsgHandler) ziface.IConnection
+
+// 连接装饰器，对创建好的连接进行包装，例如拦截 SendMsg
+type ConnDecorator func(conn ziface.IConnection) ziface.IConnection
+
+// 使用自定义的连接工厂创建连接，
+// 创建出的连接同样会加入连接管理器、触发 hook 函数并交给 MsgHandler 处理
+func WithConnFactory(factory ConnFactory) Option {
+	return func(s *Server) {
+		s.connFactory = factory
+	}
+}
+
+// 添加连接装饰器，多个装饰器按添加顺序依次包装
+func WithConnDecorator(decorator ConnDecorator) Option {
+	return func(s *Server) {
+		s.connDecorators = append(s.connDecorators, decorator)
 	}
 }
@@ -0,0 +1,336 @@
+package znet
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/dokidokikoi/my-zinx/utils"
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+// 离线消息的存储方式
+const (
+	// 保存在内存中
+	OutboxStoreMemory = "memory"
+	// 保存在 OutboxDir 目录下的追加写文件中
+	OutboxStoreFile = "file"
+)
+
+// 用户的离线消息超出配额
+var ErrOutboxFull = errors.New("outbox quota exceeded")
+
+// 离线消息的配额，值为 0 表示不限制
+type OutboxQuota struct {
+	// 每个用户最多保存的消息数
+	MaxMsgs int
+	// 每个用户最多保存的消息体字节数
+	MaxBytes int
+	// 消息保存的时长
+	TTL time.Duration
+}
+
+// 判断离线消息在 now 时是否已经过期
+func (q OutboxQuota) expired(msg ziface.OutboxMsg, now time.Time) bool {
+	return q.TTL > 0 && now.Sub(msg.Time) >= q.TTL
+}
+
+// 判断保存了 msgs 条、共 size 字节的用户能否再保存 n 字节的消息
+func (q OutboxQuota) allow(msgs, size, n int) bool {
+	if q.MaxMsgs > 0 && msgs+1 > q.MaxMsgs {
+		return false
+	}
+	return q.MaxBytes <= 0 || size+n <= q.MaxBytes
+}
+
+func outboxQuotaFromConfig() OutboxQuota {
+	return OutboxQuota{
+		MaxMsgs:  int(utils.GlobalObject.OutboxMaxMsgs),
+		MaxBytes: int(utils.GlobalObject.OutboxMaxBytes),
+		TTL:      time.Duration(utils.GlobalObject.OutboxTTL) * time.Millisecond,
+	}
+}
+
+// 按配置创建离线消息的存储
+func newOutboxStoreFromConfig() (ziface.IOutboxStore, error) {
+	quota := outboxQuotaFromConfig()
+	if utils.GlobalObject.OutboxStore == OutboxStoreFile {
+		store, err := NewFileOutboxStore(utils.GlobalObject.OutboxDir, quota)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+	return NewMemoryOutboxStore(quota), nil
+}
+
+// 同一用户的发送和投递使用同一把锁，保证消息顺序
+const outboxLockCount = 64
+
+// IOutbox 的实现
+// 作为会话管理的监听者，用户绑定连接时通过 SendBuffMsg 按顺序投递离线消息
+type Outbox struct {
+	store      ziface.IOutboxStore
+	sessionMgr ziface.ISessionManager
+	locks      [outboxLockCount]sync.Mutex
+	// 定期清理过期消息
+	expireTicker *time.Ticker
+	done         chan struct{}
+}
+
+func (o *Outbox) userLock(user string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(user))
+	return &o.locks[h.Sum32()%outboxLockCount]
+}
+
+func (o *Outbox) SendToUser(user string, msgID uint32, data []byte) error {
+	lock := o.userLock(user)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// 还有未投递的离线消息时继续排队，避免新消息先于离线消息到达
+	if o.store.Len(user) == 0 {
+		var sent bool
+		for _, conn := range o.sessionMgr.GetByUser(user) {
+			if conn.SendBuffMsg(msgID, data) == nil {
+				sent = true
+			}
+		}
+		if sent {
+			return nil
+		}
+	}
+	return o.push(user, msgID, data)
+}
+
+func (o *Outbox) Push(user string, msgID uint32, data []byte) error {
+	lock := o.userLock(user)
+	lock.Lock()
+	defer lock.Unlock()
+
+	return o.push(user, msgID, data)
+}
+
+// 保存离线消息，调用方需持有用户的锁
+func (o *Outbox) push(user string, msgID uint32, data []byte) error {
+	// 复制一份，调用方之后可以继续使用 data
+	msg := ziface.OutboxMsg{MsgID: msgID, Data: append([]byte(nil), data...), Time: time.Now()}
+	return o.store.Push(user, msg)
+}
+
+func (o *Outbox) Len(user string) int {
+	return o.store.Len(user)
+}
+
+func (o *Outbox) Clear(user string) error {
+	lock := o.userLock(user)
+	lock.Lock()
+	defer lock.Unlock()
+
+	_, err := o.store.Pop(user)
+	return err
+}
+
+// 用户绑定到连接，按顺序投递离线消息
+// 先读取消息，只删除成功加入发送队列的部分，投递过程中进程退出时消息不会丢失，
+// 剩余的消息等待用户下次绑定连接
+func (o *Outbox) sessionBound(user string, conn ziface.IConnection) {
+	lock := o.userLock(user)
+	lock.Lock()
+	defer lock.Unlock()
+
+	msgs, err := o.store.Peek(user)
+	if err != nil {
+		fmt.Println("peek outbox err", user, err)
+		return
+	}
+	sent := 0
+	for _, msg := range msgs {
+		if err := conn.SendBuffMsg(msg.MsgID, msg.Data); err != nil {
+			fmt.Println("deliver outbox err", user, err, ", left", len(msgs)-sent, "msgs")
+			break
+		}
+		sent++
+	}
+	if sent == 0 {
+		return
+	}
+	// 删除失败时已投递的消息会在下次绑定时重复投递
+	if err := o.store.Ack(user, msgs[:sent]); err != nil {
+		fmt.Println("ack outbox err", user, err)
+	}
+}
+
+// 返回存储中的消息 stored 开头需要删除的条数，acked 为 Peek 返回的开头部分，
+// 其中已经过期清理的消息不在 stored 中
+func ackedOutboxMsgs(stored, acked []ziface.OutboxMsg) int {
+	n := 0
+	for _, msg := range acked {
+		if n < len(stored) && stored[n].Seq == msg.Seq {
+			n++
+		}
+	}
+	return n
+}
+
+func (o *Outbox) sessionUnbound(user string, conn ziface.IConnection) {}
+
+// 开始定期清理过期消息
+func (o *Outbox) start(interval time.Duration) {
+	if interval <= 0 || o.expireTicker != nil {
+		return
+	}
+	o.expireTicker = time.NewTicker(interval)
+	o.done = make(chan struct{})
+	go func(ticker *time.Ticker, done chan struct{}) {
+		for {
+			select {
+			case now := <-ticker.C:
+				if err := o.store.Expire(now); err != nil {
+					fmt.Println("expire outbox err", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}(o.expireTicker, o.done)
+}
+
+// 停止清理并关闭存储
+func (o *Outbox) stop() {
+	if o.expireTicker != nil {
+		o.expireTicker.Stop()
+		close(o.done)
+		o.expireTicker = nil
+	}
+	if err := o.store.Close(); err != nil {
+		fmt.Println("close outbox store err", err)
+	}
+}
+
+func NewOutbox(store ziface.IOutboxStore, sessionMgr ziface.ISessionManager) *Outbox {
+	return &Outbox{
+		store:      store,
+		sessionMgr: sessionMgr,
+	}
+}
+
+// IOutboxStore 的内存实现
+type MemoryOutboxStore struct {
+	users map[string][]ziface.OutboxMsg
+	// 每个用户保存的消息体字节数
+	sizes map[string]int
+	quota OutboxQuota
+	// 最后分配的消息序号
+	seq  uint64
+	lock sync.Mutex
+}
+
+func (ms *MemoryOutboxStore) Push(user string, msg ziface.OutboxMsg) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	if !ms.quota.allow(len(ms.users[user]), ms.sizes[user], len(msg.Data)) {
+		// 先清理过期消息再判断一次
+		ms.expireUser(user, time.Now())
+		if !ms.quota.allow(len(ms.users[user]), ms.sizes[user], len(msg.Data)) {
+			return ErrOutboxFull
+		}
+	}
+	ms.seq++
+	msg.Seq = ms.seq
+	ms.users[user] = append(ms.users[user], msg)
+	ms.sizes[user] += len(msg.Data)
+	return nil
+}
+
+func (ms *MemoryOutboxStore) Pop(user string) ([]ziface.OutboxMsg, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	ms.expireUser(user, time.Now())
+	msgs := ms.users[user]
+	delete(ms.users, user)
+	delete(ms.sizes, user)
+	return msgs, nil
+}
+
+func (ms *MemoryOutboxStore) Peek(user string) ([]ziface.OutboxMsg, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	ms.expireUser(user, time.Now())
+	return append([]ziface.OutboxMsg(nil), ms.users[user]...), nil
+}
+
+func (ms *MemoryOutboxStore) Ack(user string, msgs []ziface.OutboxMsg) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	stored := ms.users[user]
+	n := ackedOutboxMsgs(stored, msgs)
+	if n == 0 {
+		return nil
+	}
+	if n == len(stored) {
+		delete(ms.users, user)
+		delete(ms.sizes, user)
+		return nil
+	}
+	for _, msg := range stored[:n] {
+		ms.sizes[user] -= len(msg.Data)
+	}
+	ms.users[user] = append([]ziface.OutboxMsg(nil), stored[n:]...)
+	return nil
+}
+
+func (ms *MemoryOutboxStore) Len(user string) int {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	return len(ms.users[user])
+}
+
+func (ms *MemoryOutboxStore) Expire(now time.Time) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	for user := range ms.users {
+		ms.expireUser(user, now)
+	}
+	return nil
+}
+
+// 删除用户已过期的消息，消息按保存时间排列，只需检查开头部分，调用方需持有 lock
+func (ms *MemoryOutboxStore) expireUser(user string, now time.Time) {
+	msgs := ms.users[user]
+	i := 0
+	for i < len(msgs) && ms.quota.expired(msgs[i], now) {
+		ms.sizes[user] -= len(msgs[i].Data)
+		i++
+	}
+	if i == 0 {
+		return
+	}
+	if i == len(msgs) {
+		delete(ms.users, user)
+		delete(ms.sizes, user)
+		return
+	}
+	ms.users[user] = append([]ziface.OutboxMsg(nil), msgs[i:]...)
+}
+
+func (ms *MemoryOutboxStore) Close() error {
+	return nil
+}
+
+func NewMemoryOutboxStore(quota OutboxQuota) *MemoryOutboxStore {
+	return &MemoryOutboxStore{
+		users: make(map[string][]ziface.OutboxMsg),
+		sizes: make(map[string]int),
+		quota: quota,
+	}
+}
@@ -0,0 +1,246 @@
+package znet
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+func testOutboxStores(t *testing.T, quota OutboxQuota) map[string]func() ziface.IOutboxStore {
+	dir := t.TempDir()
+	return map[string]func() ziface.IOutboxStore{
+		"memory": func() ziface.IOutboxStore { return NewMemoryOutboxStore(quota) },
+		"file": func() ziface.IOutboxStore {
+			store, err := NewFileOutboxStore(dir, quota)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store
+		},
+	}
+}
+
+func TestOutboxStore(t *testing.T) {
+	quota := OutboxQuota{MaxMsgs: 3, MaxBytes: 10, TTL: time.Minute}
+	for name, newStore := range testOutboxStores(t, quota) {
+		t.Run(name, func(t *testing.T) {
+			store := newStore()
+			defer store.Close()
+			now := time.Now()
+
+			// 第一条消息已过期，清理后为后面的消息腾出配额
+			msgs := []ziface.OutboxMsg{
+				{MsgID: 1, Data: []byte("old"), Time: now.Add(-2 * time.Minute)},
+				{MsgID: 2, Data: []byte("ab"), Time: now},
+				{MsgID: 3, Data: []byte("cd"), Time: now},
+				{MsgID: 4, Data: []byte("ef"), Time: now},
+			}
+			for _, msg := range msgs {
+				if err := store.Push("alice", msg); err != nil {
+					t.Fatalf("push msg %d: %v", msg.MsgID, err)
+				}
+			}
+			if err := store.Push("alice", ziface.OutboxMsg{MsgID: 5, Time: now}); err != ErrOutboxFull {
+				t.Fatalf("push over MaxMsgs err = %v, want ErrOutboxFull", err)
+			}
+			if err := store.Push("bob", ziface.OutboxMsg{MsgID: 6, Data: make([]byte, 11), Time: now}); err != ErrOutboxFull {
+				t.Fatalf("push over MaxBytes err = %v, want ErrOutboxFull", err)
+			}
+
+			got, err := store.Pop("alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 3 || got[0].MsgID != 2 || got[2].MsgID != 4 || string(got[1].Data) != "cd" {
+				t.Fatalf("Pop = %+v", got)
+			}
+			if store.Len("alice") != 0 {
+				t.Fatalf("Len after pop = %d", store.Len("alice"))
+			}
+
+			_ = store.Push("bob", ziface.OutboxMsg{MsgID: 7, Time: now.Add(-2 * time.Minute)})
+			if err := store.Expire(now); err != nil || store.Len("bob") != 0 {
+				t.Fatalf("Expire err = %v, Len = %d", err, store.Len("bob"))
+			}
+		})
+	}
+}
+
+func TestOutboxStorePeekAck(t *testing.T) {
+	for name, newStore := range testOutboxStores(t, OutboxQuota{TTL: time.Minute}) {
+		t.Run(name, func(t *testing.T) {
+			store := newStore()
+			defer store.Close()
+			now := time.Now()
+
+			for i, age := range []time.Duration{50 * time.Second, 0, 0} {
+				if err := store.Push("alice", ziface.OutboxMsg{MsgID: uint32(i + 1), Time: now.Add(-age)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			peeked, err := store.Peek("alice")
+			if err != nil || len(peeked) != 3 || store.Len("alice") != 3 {
+				t.Fatalf("Peek = %+v, %v, Len = %d", peeked, err, store.Len("alice"))
+			}
+
+			// 投递期间第一条消息过期被清理，又保存了一条新消息
+			if err := store.Expire(now.Add(20 * time.Second)); err != nil {
+				t.Fatal(err)
+			}
+			_ = store.Push("alice", ziface.OutboxMsg{MsgID: 4, Time: now})
+			if err := store.Ack("alice", peeked[:2]); err != nil {
+				t.Fatal(err)
+			}
+			got, err := store.Peek("alice")
+			if err != nil || len(got) != 2 || got[0].MsgID != 3 || got[1].MsgID != 4 {
+				t.Fatalf("Peek after ack = %+v, %v", got, err)
+			}
+
+			// MsgID 和保存时间都相同的消息按序号分别确认
+			for i := 0; i < 2; i++ {
+				_ = store.Push("bob", ziface.OutboxMsg{MsgID: 1, Time: now})
+			}
+			peeked, _ = store.Peek("bob")
+			if len(peeked) != 2 || peeked[0].Seq == peeked[1].Seq {
+				t.Fatalf("Peek bob = %+v, want distinct seqs", peeked)
+			}
+			if err := store.Ack("bob", peeked[:1]); err != nil || store.Len("bob") != 1 {
+				t.Fatalf("Ack one of two err = %v, Len = %d, want 1", err, store.Len("bob"))
+			}
+		})
+	}
+}
+
+func TestFileOutboxStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileOutboxStore(dir, OutboxQuota{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint32(1); i <= 3; i++ {
+		if err := store.Push("alice", ziface.OutboxMsg{MsgID: i, Data: []byte("hi"), Time: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 模拟写入过程中进程退出，文件末尾留下不完整的消息
+	path := store.path("alice")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{1, 2, 3})
+	f.Close()
+
+	store, err = NewFileOutboxStore(dir, OutboxQuota{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Len("alice") != 3 {
+		t.Fatalf("Len after reload = %d, want 3", store.Len("alice"))
+	}
+	_ = store.Push("alice", ziface.OutboxMsg{MsgID: 4, Time: time.Now()})
+	got, err := store.Pop("alice")
+	if err != nil || len(got) != 4 || got[3].MsgID != 4 {
+		t.Fatalf("Pop = %+v, %v", got, err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 0 {
+		t.Fatalf("files left after pop: %v", files)
+	}
+}
+
+func TestFileOutboxStoreLongUser(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileOutboxStore(dir, OutboxQuota{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 超过文件名长度限制的用户名
+	user := strings.Repeat("用户", 100)
+	if err := store.Push(user, ziface.OutboxMsg{MsgID: 1, Data: []byte("hi"), Time: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	// 截断在用户名中间的文件在加载时被删除
+	if err := os.WriteFile(filepath.Join(dir, "broken"+outboxFileSuffix), []byte{10, 0, 'a'}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新加载后从文件开头恢复用户名，序号继续递增
+	store, err = NewFileOutboxStore(dir, OutboxQuota{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Len(user) != 1 {
+		t.Fatalf("Len after reload = %d, want 1", store.Len(user))
+	}
+	_ = store.Push(user, ziface.OutboxMsg{MsgID: 2, Time: time.Now()})
+	got, err := store.Pop(user)
+	if err != nil || len(got) != 2 || string(got[0].Data) != "hi" || got[1].Seq <= got[0].Seq {
+		t.Fatalf("Pop = %+v, %v", got, err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 0 {
+		t.Fatalf("files left after pop: %v", files)
+	}
+}
+
+func TestOutboxOffline(t *testing.T) {
+	outbox := NewOutbox(NewMemoryOutboxStore(OutboxQuota{}), NewSessionManager())
+	data := []byte("hello")
+	if err := outbox.SendToUser("alice", 1, data); err != nil {
+		t.Fatal(err)
+	}
+	data[0] = 'j'
+	if outbox.Len("alice") != 1 {
+		t.Fatalf("Len = %d, want 1", outbox.Len("alice"))
+	}
+	msgs, _ := outbox.store.Pop("alice")
+	if string(msgs[0].Data) != "hello" {
+		t.Fatalf("queued data = %q, want a copy of the original", msgs[0].Data)
+	}
+}
+
+// 只有前 left 次发送成功的连接
+type limitedSendConn struct {
+	ziface.IConnection
+	left int
+}
+
+func (c *limitedSendConn) SendBuffMsg(msgID uint32, data []byte) error {
+	if c.left == 0 {
+		return ErrSendQueueFull
+	}
+	c.left--
+	return c.IConnection.SendBuffMsg(msgID, data)
+}
+
+func TestOutboxDeliverPartial(t *testing.T) {
+	store := NewMemoryOutboxStore(OutboxQuota{})
+	s := newTestServer(t, WithOutboxStore(store), WithConnDecorator(func(conn ziface.IConnection) ziface.IConnection {
+		return &limitedSendConn{IConnection: conn, left: 2}
+	}))
+	s.AddRouter(testLoginMsgID, &testLoginRouter{server: s})
+	for _, data := range []string{"m1", "m2", "m3"} {
+		if err := s.GetOutbox().Push("alice", 10, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 只有成功加入发送队列的消息从离线消息中删除
+	client, _ := dialTestServer(t, s)
+	r := bufio.NewReader(client)
+	writeTestFrame(t, client, s.Codec(), testLoginMsgID, []byte("alice"))
+	for _, want := range []string{"m1", "m2"} {
+		if _, data := readTestFrame(t, client, r, s.Codec()); data != want {
+			t.Fatalf("delivered %q, want %q", data, want)
+		}
+	}
+	waitFor(t, "ack", func() bool { return store.Len("alice") == 1 })
+	if msgs, _ := store.Peek("alice"); len(msgs) != 1 || string(msgs[0].Data) != "m3" {
+		t.Fatalf("left in outbox %+v, want m3", msgs)
+	}
+}
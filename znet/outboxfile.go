@@ -0,0 +1,362 @@
+package znet
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+// 离线消息文件的后缀，文件名为用户名 SHA-256 的十六进制编码，不受用户名长度和字符的限制
+const outboxFileSuffix = ".outbox"
+
+// 文件开头保存用户名: 用户名长度 uint16(小端序) + 用户名
+const outboxFileHeadLen = 2
+
+// 文件中每条消息的头部长度
+// 保存时间 int64(UnixNano) + 序号 uint64 + MsgID uint32 + 消息体长度 uint32，小端序，之后为消息体
+const outboxRecordHeadLen = 24
+
+// 用户名过长，无法写入离线消息文件
+var ErrOutboxUserTooLong = errors.New("outbox user name too long")
+
+// 用户离线消息文件的概要信息，用于判断配额和过期
+type fileOutboxMeta struct {
+	msgs   int
+	size   int
+	oldest time.Time
+}
+
+// IOutboxStore 的文件实现，每个用户一个追加写的文件
+// 取出、确认投递或清理过期消息时重写整个文件，进程重启后可以从文件中恢复离线消息
+type FileOutboxStore struct {
+	dir   string
+	quota OutboxQuota
+	meta  map[string]*fileOutboxMeta
+	// 最后分配的消息序号，加载时从文件中的最大序号继续
+	seq  uint64
+	lock sync.Mutex
+}
+
+func (fs *FileOutboxStore) path(user string) string {
+	sum := sha256.Sum256([]byte(user))
+	return filepath.Join(fs.dir, hex.EncodeToString(sum[:])+outboxFileSuffix)
+}
+
+// 将文件开头的用户名追加到 dst
+func appendOutboxHead(dst []byte, user string) []byte {
+	dst = binary.LittleEndian.AppendUint16(dst, uint16(len(user)))
+	return append(dst, user...)
+}
+
+// 将消息编码后追加到 dst
+func appendOutboxRecord(dst []byte, msg ziface.OutboxMsg) []byte {
+	dst = binary.LittleEndian.AppendUint64(dst, uint64(msg.Time.UnixNano()))
+	dst = binary.LittleEndian.AppendUint64(dst, msg.Seq)
+	dst = binary.LittleEndian.AppendUint32(dst, msg.MsgID)
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(msg.Data)))
+	return append(dst, msg.Data...)
+}
+
+// 读取离线消息文件，返回文件中的用户名、消息和完整内容的长度，
+// 文件末尾不完整的消息(写入过程中进程退出)会被忽略，文件不存在或用户名不完整时用户名为空
+func readOutboxFile(path string) (string, []ziface.OutboxMsg, int64, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil, 0, nil
+	}
+	if err != nil {
+		return "", nil, 0, err
+	}
+	if len(data) < outboxFileHeadLen {
+		return "", nil, 0, nil
+	}
+	headLen := outboxFileHeadLen + int(binary.LittleEndian.Uint16(data))
+	if len(data) < headLen {
+		return "", nil, 0, nil
+	}
+	user := string(data[outboxFileHeadLen:headLen])
+	valid := int64(headLen)
+	data = data[headLen:]
+
+	var msgs []ziface.OutboxMsg
+	for len(data) >= outboxRecordHeadLen {
+		dataLen := binary.LittleEndian.Uint32(data[20:24])
+		if uint64(len(data)-outboxRecordHeadLen) < uint64(dataLen) {
+			break
+		}
+		end := outboxRecordHeadLen + int(dataLen)
+		msgs = append(msgs, ziface.OutboxMsg{
+			Time:  time.Unix(0, int64(binary.LittleEndian.Uint64(data[0:8]))),
+			Seq:   binary.LittleEndian.Uint64(data[8:16]),
+			MsgID: binary.LittleEndian.Uint32(data[16:20]),
+			Data:  data[outboxRecordHeadLen:end:end],
+		})
+		valid += int64(end)
+		data = data[end:]
+	}
+	return user, msgs, valid, nil
+}
+
+// 读取用户的离线消息
+func (fs *FileOutboxStore) read(user string) ([]ziface.OutboxMsg, error) {
+	_, msgs, _, err := readOutboxFile(fs.path(user))
+	return msgs, err
+}
+
+// 用 msgs 重写用户的离线消息文件，msgs 为空时删除文件
+func writeOutboxFile(path string, user string, msgs []ziface.OutboxMsg) error {
+	if len(msgs) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	buf := appendOutboxHead(nil, user)
+	for _, msg := range msgs {
+		buf = appendOutboxRecord(buf, msg)
+	}
+	// 先写临时文件再替换，避免写入过程中进程退出导致文件损坏
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (fs *FileOutboxStore) Push(user string, msg ziface.OutboxMsg) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	m := fs.meta[user]
+	if m != nil && !fs.quota.allow(m.msgs, m.size, len(msg.Data)) {
+		// 先清理过期消息再判断一次
+		if err := fs.compact(user, time.Now()); err != nil {
+			return err
+		}
+		if m = fs.meta[user]; m != nil && !fs.quota.allow(m.msgs, m.size, len(msg.Data)) {
+			return ErrOutboxFull
+		}
+	}
+	if m == nil && !fs.quota.allow(0, 0, len(msg.Data)) {
+		return ErrOutboxFull
+	}
+	if len(user) > math.MaxUint16 {
+		return ErrOutboxUserTooLong
+	}
+
+	msg.Seq = fs.seq + 1
+	if err := fs.appendFile(user, m == nil, msg); err != nil {
+		return err
+	}
+	fs.seq = msg.Seq
+
+	if m == nil {
+		m = &fileOutboxMeta{oldest: msg.Time}
+		fs.meta[user] = m
+	}
+	m.msgs++
+	m.size += len(msg.Data)
+	return nil
+}
+
+// 将消息追加到用户的离线消息文件，create 为 true 时文件中还没有消息，需要先写入用户名
+// 只写入了一部分时截断到写入之前的长度，避免之后追加的消息跟在不完整的消息后面无法解析
+func (fs *FileOutboxStore) appendFile(user string, create bool, msg ziface.OutboxMsg) error {
+	flag := os.O_WRONLY | os.O_CREATE
+	if create {
+		flag |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(fs.path(user), flag, 0644)
+	if err != nil {
+		return err
+	}
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err == nil {
+		var buf []byte
+		if create {
+			buf = appendOutboxHead(buf, user)
+		}
+		if _, err = f.Write(appendOutboxRecord(buf, msg)); err != nil {
+			_ = f.Truncate(offset)
+		}
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (fs *FileOutboxStore) Pop(user string) ([]ziface.OutboxMsg, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	if _, ok := fs.meta[user]; !ok {
+		return nil, nil
+	}
+	msgs, err := fs.read(user)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeOutboxFile(fs.path(user), user, nil); err != nil {
+		return nil, err
+	}
+	delete(fs.meta, user)
+
+	now := time.Now()
+	for len(msgs) > 0 && fs.quota.expired(msgs[0], now) {
+		msgs = msgs[1:]
+	}
+	return msgs, nil
+}
+
+func (fs *FileOutboxStore) Peek(user string) ([]ziface.OutboxMsg, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	if _, ok := fs.meta[user]; !ok {
+		return nil, nil
+	}
+	msgs, err := fs.read(user)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for len(msgs) > 0 && fs.quota.expired(msgs[0], now) {
+		msgs = msgs[1:]
+	}
+	return msgs, nil
+}
+
+func (fs *FileOutboxStore) Ack(user string, msgs []ziface.OutboxMsg) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	if _, ok := fs.meta[user]; !ok {
+		return nil
+	}
+	stored, err := fs.read(user)
+	if err != nil {
+		return err
+	}
+	n := ackedOutboxMsgs(stored, msgs)
+	if n == 0 {
+		return nil
+	}
+	if err := writeOutboxFile(fs.path(user), user, stored[n:]); err != nil {
+		return err
+	}
+	fs.setMeta(user, stored[n:])
+	return nil
+}
+
+func (fs *FileOutboxStore) Len(user string) int {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	if m, ok := fs.meta[user]; ok {
+		return m.msgs
+	}
+	return 0
+}
+
+func (fs *FileOutboxStore) Expire(now time.Time) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	for user, m := range fs.meta {
+		if fs.quota.TTL <= 0 || now.Sub(m.oldest) < fs.quota.TTL {
+			continue
+		}
+		if err := fs.compact(user, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 删除用户已过期的消息并重写文件，调用方需持有 lock
+func (fs *FileOutboxStore) compact(user string, now time.Time) error {
+	msgs, err := fs.read(user)
+	if err != nil {
+		return err
+	}
+	i := 0
+	for i < len(msgs) && fs.quota.expired(msgs[i], now) {
+		i++
+	}
+	msgs = msgs[i:]
+	if err := writeOutboxFile(fs.path(user), user, msgs); err != nil {
+		return err
+	}
+	fs.setMeta(user, msgs)
+	return nil
+}
+
+// 根据文件中的消息更新概要信息，调用方需持有 lock
+func (fs *FileOutboxStore) setMeta(user string, msgs []ziface.OutboxMsg) {
+	if len(msgs) == 0 {
+		delete(fs.meta, user)
+		return
+	}
+	m := &fileOutboxMeta{msgs: len(msgs), oldest: msgs[0].Time}
+	for _, msg := range msgs {
+		m.size += len(msg.Data)
+	}
+	fs.meta[user] = m
+}
+
+func (fs *FileOutboxStore) Close() error {
+	return nil
+}
+
+// 创建文件存储，并加载 dir 目录下已有的离线消息
+func NewFileOutboxStore(dir string, quota OutboxQuota) (*FileOutboxStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	fs := &FileOutboxStore{
+		dir:   dir,
+		quota: quota,
+		meta:  make(map[string]*fileOutboxMeta),
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, outboxFileSuffix) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		user, msgs, valid, err := readOutboxFile(path)
+		if err != nil {
+			return nil, err
+		}
+		// 去掉文件末尾不完整的消息，避免之后追加的消息无法解析
+		if info, err := entry.Info(); err == nil && info.Size() != valid {
+			if err := writeOutboxFile(path, user, msgs); err != nil {
+				return nil, err
+			}
+		}
+		for _, msg := range msgs {
+			if msg.Seq > fs.seq {
+				fs.seq = msg.Seq
+			}
+		}
+		fs.setMeta(user, msgs)
+	}
+	return fs, nil
+}
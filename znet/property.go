@@ -0,0 +1,105 @@
+package znet
+
+import (
+	"errors"
+
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+// 获取不存在的连接属性时返回的错误
+var ErrPropertyNotFound = errors.New("no property found")
+
+// 已注册的属性监听函数，使用指针区分每一次注册，便于取消
+type propertyWatch struct {
+	fn ziface.PropertyWatcher
+}
+
+// 带类型的连接属性键
+// 通过 Key 读写属性时可以在编译期确定属性值的类型，属性不存在或类型不符时返回默认值
+type Key[T any] struct {
+	name string
+	def  T
+}
+
+// 创建一个属性键，def 为属性不存在时的默认值
+func NewKey[T any](name string, def T) Key[T] {
+	return Key[T]{name: name, def: def}
+}
+
+// 获取属性名
+func (k Key[T]) Name() string {
+	return k.name
+}
+
+// 获取属性的默认值
+func (k Key[T]) Default() T {
+	return k.def
+}
+
+// 将属性值转换为 T，属性不存在或类型不符时返回默认值和 false
+func (k Key[T]) value(v interface{}, exists bool) (T, bool) {
+	if !exists {
+		return k.def, false
+	}
+	val, ok := v.(T)
+	if !ok {
+		return k.def, false
+	}
+	return val, true
+}
+
+// 获取属性值，属性不存在或类型不符时返回默认值
+func (k Key[T]) Get(conn ziface.IConnection) T {
+	val, _ := k.Lookup(conn)
+	return val
+}
+
+// 获取属性值，并返回属性是否存在且类型正确
+func (k Key[T]) Lookup(conn ziface.IConnection) (T, bool) {
+	v, err := conn.GetProperty(k.name)
+	return k.value(v, err == nil)
+}
+
+// 设置属性值
+func (k Key[T]) Set(conn ziface.IConnection, value T) {
+	conn.SetProperty(k.name, value)
+}
+
+// 移除属性
+func (k Key[T]) Remove(conn ziface.IConnection) {
+	conn.RemoveProperty(k.name)
+}
+
+// 原子地更新属性值，fn 的参数为当前值(不存在时为默认值)，返回更新后的值
+func (k Key[T]) Update(conn ziface.IConnection, fn func(old T) T) T {
+	var result T
+	conn.UpdateProperty(k.name, func(old interface{}, exists bool) (interface{}, ziface.PropertyAction) {
+		cur, _ := k.value(old, exists)
+		result = fn(cur)
+		return result, ziface.PropertySet
+	})
+	return result
+}
+
+// 监听属性的变化，属性不存在或类型不符时以默认值通知，返回取消监听的函数
+func (k Key[T]) Watch(conn ziface.IConnection, fn func(conn ziface.IConnection, old, new T)) func() {
+	return conn.WatchProperty(k.name, func(conn ziface.IConnection, change ziface.PropertyChange) {
+		old, _ := k.value(change.Old, change.OldExists)
+		val, _ := k.value(change.New, change.NewExists)
+		fn(conn, old, val)
+	})
+}
+
+// 当属性的当前值(不存在时为默认值)等于 old 时将其设置为 new，返回是否设置成功
+func CompareAndSwap[T comparable](conn ziface.IConnection, k Key[T], old, new T) bool {
+	swapped := false
+	conn.UpdateProperty(k.name, func(v interface{}, exists bool) (interface{}, ziface.PropertyAction) {
+		cur, _ := k.value(v, exists)
+		if cur != old {
+			return nil, ziface.PropertyKeep
+		}
+		swapped = true
+		return new, ziface.PropertySet
+	})
+	return swapped
+}
@@ -0,0 +1,54 @@
+package znet
+
+import (
+	"testing"
+
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+func TestTypedProperty(t *testing.T) {
+	conn := &Connection{}
+	uid := NewKey[uint64]("uid", 0)
+	score := NewKey("score", 100)
+
+	var changes []ziface.PropertyChange
+	cancel := conn.WatchProperty("", func(_ ziface.IConnection, change ziface.PropertyChange) {
+		changes = append(changes, change)
+	})
+
+	var loginUID uint64
+	uid.Watch(conn, func(_ ziface.IConnection, _, new uint64) {
+		loginUID = new
+	})
+
+	if score.Get(conn) != 100 {
+		t.Fatalf("default score = %d", score.Get(conn))
+	}
+	uid.Set(conn, 42)
+	if loginUID != 42 || uid.Get(conn) != 42 {
+		t.Fatalf("uid = %d, watcher got %d", uid.Get(conn), loginUID)
+	}
+
+	// 类型不符时返回默认值
+	conn.SetProperty("uid", "not a number")
+	if v, ok := uid.Lookup(conn); ok || v != 0 {
+		t.Fatalf("Lookup wrong type = %d, %v", v, ok)
+	}
+
+	if got := score.Update(conn, func(old int) int { return old + 1 }); got != 101 {
+		t.Fatalf("Update = %d", got)
+	}
+	if CompareAndSwap(conn, score, 100, 200) {
+		t.Fatal("CompareAndSwap should fail")
+	}
+	if !CompareAndSwap(conn, score, 101, 200) || score.Get(conn) != 200 {
+		t.Fatalf("CompareAndSwap failed, score = %d", score.Get(conn))
+	}
+
+	cancel()
+	score.Remove(conn)
+	// uid 设置两次、score 更新一次、交换一次，失败的交换不产生通知
+	if len(changes) != 4 {
+		t.Fatalf("got %d changes, want 4", len(changes))
+	}
+}
@@ -0,0 +1,178 @@
+package znet
+
+import (
+	"time"
+
+	"github.com/dokidokikoi/my-zinx/utils"
+)
+
+// 超过限流时的处理策略
+const (
+	// 丢弃超出限流的消息
+	RateLimitDrop = "drop"
+	// 暂停读取该连接，等到配额恢复后再处理消息
+	RateLimitDelay = "delay"
+	// 丢弃消息并向客户端发送错误帧
+	RateLimitError = "error"
+	// 丢弃消息，违规次数达到上限后断开连接
+	RateLimitDisconnect = "disconnect"
+)
+
+// 令牌桶，每秒补充 rate 个令牌，最多积攒一秒的配额
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// rate 为 0 表示不限制，返回 nil
+func newTokenBucket(rate uint32, now time.Time) *tokenBucket {
+	if rate == 0 {
+		return nil
+	}
+	return &tokenBucket{
+		rate:   float64(rate),
+		tokens: float64(rate),
+		last:   now,
+	}
+}
+
+// 按流逝的时间补充令牌
+func (b *tokenBucket) refill(now time.Time) {
+	if b == nil {
+		return
+	}
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+}
+
+// 计算取出 n 个令牌还需要等待的时间，0 表示可以立即取出
+// n 超过桶的容量时等到桶满即可取出，透支的部分由之后补充的令牌偿还
+func (b *tokenBucket) wait(n float64) time.Duration {
+	if b == nil {
+		return 0
+	}
+	if n > b.rate {
+		n = b.rate
+	}
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// 取出 n 个令牌，允许透支
+func (b *tokenBucket) take(n float64) {
+	if b == nil {
+		return
+	}
+	b.tokens -= n
+}
+
+// 一组消息数和字节数的令牌桶
+type rateBuckets struct {
+	msgs  *tokenBucket
+	bytes *tokenBucket
+}
+
+func newRateBuckets(limit utils.RateLimit, now time.Time) *rateBuckets {
+	return &rateBuckets{
+		msgs:  newTokenBucket(limit.MsgPerSec, now),
+		bytes: newTokenBucket(limit.BytesPerSec, now),
+	}
+}
+
+func (rb *rateBuckets) refill(now time.Time) {
+	rb.msgs.refill(now)
+	rb.bytes.refill(now)
+}
+
+func (rb *rateBuckets) wait(size int) time.Duration {
+	msgWait, bytesWait := rb.msgs.wait(1), rb.bytes.wait(float64(size))
+	if msgWait > bytesWait {
+		return msgWait
+	}
+	return bytesWait
+}
+
+func (rb *rateBuckets) take(size int) {
+	rb.msgs.take(1)
+	rb.bytes.take(float64(size))
+}
+
+// 单个连接的入站限流器，只在该连接的 Reader Goroutine 中使用
+type connLimiter struct {
+	// 连接级别的限流
+	conn *rateBuckets
+	// MsgID 级别的限流，首次收到对应消息时创建
+	msgIDs map[uint32]*rateBuckets
+	// 违规处理策略
+	policy string
+	// 统计窗口内的违规次数
+	strikes uint32
+	// 上次违规的时间
+	lastStrike time.Time
+}
+
+// 根据全局配置创建限流器，没有配置任何限流时返回 nil
+func newConnLimiter() *connLimiter {
+	g := utils.GlobalObject
+	if g.MaxMsgPerSec == 0 && g.MaxBytesPerSec == 0 && len(g.MsgRateLimits) == 0 {
+		return nil
+	}
+	return &connLimiter{
+		conn:   newRateBuckets(utils.RateLimit{MsgPerSec: g.MaxMsgPerSec, BytesPerSec: g.MaxBytesPerSec}, time.Now()),
+		msgIDs: make(map[uint32]*rateBuckets),
+		policy: g.RateLimitPolicy,
+	}
+}
+
+// 获取 msgID 对应的限流，没有配置时返回 nil
+func (l *connLimiter) msgIDBuckets(msgID uint32, now time.Time) *rateBuckets {
+	if rb, ok := l.msgIDs[msgID]; ok {
+		return rb
+	}
+	limit, ok := utils.GlobalObject.MsgRateLimits[msgID]
+	if !ok {
+		return nil
+	}
+	rb := newRateBuckets(limit, now)
+	l.msgIDs[msgID] = rb
+	return rb
+}
+
+// 检查一条消息是否超出限流，返回需要等待的时间以及是否由 MsgID 级别的限流触发
+func (l *connLimiter) check(msgID uint32, size int, now time.Time) (time.Duration, bool) {
+	l.conn.refill(now)
+	wait := l.conn.wait(size)
+
+	if rb := l.msgIDBuckets(msgID, now); rb != nil {
+		rb.refill(now)
+		if msgIDWait := rb.wait(size); msgIDWait > wait {
+			return msgIDWait, true
+		}
+	}
+	return wait, false
+}
+
+// 记录一次违规并返回违规次数，距上次违规超过统计窗口时重新计数
+func (l *connLimiter) strike(now time.Time) uint32 {
+	window := time.Duration(utils.GlobalObject.RateLimitStrikeWindow) * time.Millisecond
+	if window > 0 && now.Sub(l.lastStrike) >= window {
+		l.strikes = 0
+	}
+	l.strikes++
+	l.lastStrike = now
+	return l.strikes
+}
+
+// 消息通过限流，扣除对应的配额
+func (l *connLimiter) take(msgID uint32, size int) {
+	l.conn.take(size)
+	if rb, ok := l.msgIDs[msgID]; ok {
+		rb.take(size)
+	}
+}
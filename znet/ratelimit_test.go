@@ -0,0 +1,89 @@
+package znet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dokidokikoi/my-zinx/utils"
+)
+
+func TestConnLimiter(t *testing.T) {
+	old := *utils.GlobalObject
+	defer func() { *utils.GlobalObject = old }()
+
+	utils.GlobalObject.MaxMsgPerSec = 2
+	utils.GlobalObject.MaxBytesPerSec = 0
+	utils.GlobalObject.MsgRateLimits = map[uint32]utils.RateLimit{
+		7: {BytesPerSec: 10},
+	}
+
+	l := newConnLimiter()
+	now := l.conn.msgs.last
+
+	// 连接级别每秒 2 条消息
+	for i := 0; i < 2; i++ {
+		if wait, _ := l.check(1, 0, now); wait != 0 {
+			t.Fatalf("msg %d should pass, wait %v", i, wait)
+		}
+		l.take(1, 0)
+	}
+	if wait, perMsgID := l.check(1, 0, now); wait != 500*time.Millisecond || perMsgID {
+		t.Fatalf("third msg wait = %v perMsgID = %v", wait, perMsgID)
+	}
+
+	// 一秒后配额恢复，MsgID 7 每秒 10 字节
+	now = now.Add(time.Second)
+	if wait, _ := l.check(7, 8, now); wait != 0 {
+		t.Fatalf("msgID 7 should pass, wait %v", wait)
+	}
+	l.take(7, 8)
+	if wait, perMsgID := l.check(7, 8, now); wait == 0 || !perMsgID {
+		t.Fatalf("msgID 7 should be limited, wait = %v perMsgID = %v", wait, perMsgID)
+	}
+}
+
+func TestConnLimiterOversizedMsg(t *testing.T) {
+	old := *utils.GlobalObject
+	defer func() { *utils.GlobalObject = old }()
+	utils.GlobalObject.MaxMsgPerSec = 0
+	utils.GlobalObject.MaxBytesPerSec = 100
+	utils.GlobalObject.MsgRateLimits = nil
+
+	// 超过每秒字节数的消息在桶满时可以通过，透支的配额由之后的时间偿还
+	l := newConnLimiter()
+	now := l.conn.bytes.last
+	if wait, _ := l.check(1, 250, now); wait != 0 {
+		t.Fatalf("oversized msg with a full bucket wait = %v, want 0", wait)
+	}
+	l.take(1, 250)
+	if wait, _ := l.check(1, 250, now); wait != 2500*time.Millisecond {
+		t.Fatalf("next oversized msg wait = %v, want 2.5s", wait)
+	}
+	if wait, _ := l.check(1, 10, now.Add(time.Second)); wait != 600*time.Millisecond {
+		t.Fatalf("small msg during debt wait = %v, want 600ms", wait)
+	}
+}
+
+func TestConnLimiterStrikeWindow(t *testing.T) {
+	old := *utils.GlobalObject
+	defer func() { *utils.GlobalObject = old }()
+	utils.GlobalObject.MaxMsgPerSec = 1
+	utils.GlobalObject.RateLimitStrikeWindow = 1000
+
+	l := newConnLimiter()
+	now := time.Now()
+	for i := uint32(1); i <= 2; i++ {
+		if strikes := l.strike(now.Add(time.Duration(i) * 500 * time.Millisecond)); strikes != i {
+			t.Fatalf("strike %d = %d", i, strikes)
+		}
+	}
+	// 超过统计窗口没有违规后重新计数
+	if strikes := l.strike(now.Add(2500 * time.Millisecond)); strikes != 1 {
+		t.Fatalf("strikes after window = %d, want 1", strikes)
+	}
+
+	utils.GlobalObject.RateLimitStrikeWindow = 0
+	if strikes := l.strike(now.Add(time.Hour)); strikes != 2 {
+		t.Fatalf("strikes without window = %d, want 2", strikes)
+	}
+}
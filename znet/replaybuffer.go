@@ -0,0 +1,80 @@
+package znet
+
+import (
+	"errors"
+	"sync"
+)
+
+// 重放缓冲中所需的消息已被丢弃
+var ErrReplayUnavailable = errors.New("replay frames no longer available")
+
+// IReplayBuffer 的实现，最多保留 size 条未确认的消息，超出时丢弃最早的消息
+type ReplayBuffer struct {
+	// 保留的消息，frames[0] 的序号为 firstSeq
+	frames   [][]byte
+	firstSeq uint64
+	lastSeq  uint64
+	size     int
+	lock     sync.Mutex
+}
+
+func (rb *ReplayBuffer) Record(frame []byte) uint64 {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+
+	rb.lastSeq++
+	rb.frames = append(rb.frames, frame)
+	if len(rb.frames) > rb.size {
+		rb.frames[0] = nil
+		rb.frames = rb.frames[1:]
+		rb.firstSeq++
+	}
+	return rb.lastSeq
+}
+
+func (rb *ReplayBuffer) Ack(seq uint64) {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+
+	for len(rb.frames) > 0 && rb.firstSeq <= seq {
+		rb.frames[0] = nil
+		rb.frames = rb.frames[1:]
+		rb.firstSeq++
+	}
+}
+
+func (rb *ReplayBuffer) LastSeq() uint64 {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+
+	return rb.lastSeq
+}
+
+func (rb *ReplayBuffer) Since(seq uint64) ([][]byte, error) {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+
+	if seq > rb.lastSeq || seq+1 < rb.firstSeq {
+		return nil, ErrReplayUnavailable
+	}
+	frames := make([][]byte, 0, rb.lastSeq-seq)
+	return append(frames, rb.frames[seq+1-rb.firstSeq:]...), nil
+}
+
+// 创建从 seq 之后开始编号的重放缓冲，用于重放缓冲已经丢失的会话
+func newReplayBufferFrom(size int, seq uint64) *ReplayBuffer {
+	rb := NewReplayBuffer(size)
+	rb.firstSeq = seq + 1
+	rb.lastSeq = seq
+	return rb
+}
+
+func NewReplayBuffer(size int) *ReplayBuffer {
+	if size < 1 {
+		size = 1
+	}
+	return &ReplayBuffer{
+		firstSeq: 1,
+		size:     size,
+	}
+}
@@ -0,0 +1,33 @@
+package znet
+
+import "testing"
+
+func TestReplayBuffer(t *testing.T) {
+	rb := NewReplayBuffer(3)
+	for _, frame := range []string{"a", "b", "c", "d"} {
+		rb.Record([]byte(frame))
+	}
+	if rb.LastSeq() != 4 {
+		t.Fatalf("LastSeq = %d, want 4", rb.LastSeq())
+	}
+
+	// "a" 已被挤出缓冲
+	if _, err := rb.Since(0); err != ErrReplayUnavailable {
+		t.Fatalf("Since(0) err = %v, want ErrReplayUnavailable", err)
+	}
+	frames, err := rb.Since(2)
+	if err != nil || len(frames) != 2 || string(frames[0]) != "c" || string(frames[1]) != "d" {
+		t.Fatalf("Since(2) = %q, %v", frames, err)
+	}
+
+	rb.Ack(3)
+	if _, err := rb.Since(2); err != ErrReplayUnavailable {
+		t.Fatalf("Since(2) after ack err = %v, want ErrReplayUnavailable", err)
+	}
+	if frames, err := rb.Since(4); err != nil || len(frames) != 0 {
+		t.Fatalf("Since(4) = %q, %v", frames, err)
+	}
+	if _, err := rb.Since(5); err != ErrReplayUnavailable {
+		t.Fatalf("Since(5) err = %v, want ErrReplayUnavailable", err)
+	}
+}
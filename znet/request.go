@@ -1,12 +1,29 @@
 package znet
 
-import "github.com/dokidokikoi/my-zinx/ziface"
+import (
+	"context"
+	"time"
+
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+// 开始执行处理方法时需要通知的请求，如 Request 从此时开始计算请求超时
+type dispatchedRequest interface {
+	startDispatch()
+}
 
 type Request struct {
-	// 已经和客户端建立好连接
+	// 已经和客户端建立好的连接
 	conn ziface.IConnection
 	// 客户端请求的数据
 	msg ziface.IMessage
+	// 消息体所使用的池化缓冲，处理完成后归还
+	buf *[]byte
+	// 请求的 context 及其取消函数，派生自连接的 context，未设置请求超时时 cancel 为 nil
+	ctx    context.Context
+	cancel context.CancelFunc
+	// 请求的处理超时时间，开始执行处理方法时设置截止时间，0 表示不限制
+	timeout time.Duration
 }
 
 // 获取请求连接的数据
@@ -22,3 +39,32 @@ func (r *Request) GetData() []byte {
 func (r *Request) GetMsgID() uint32 {
 	return r.msg.GetMsgID()
 }
+
+// 获取请求的 context
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// 开始执行处理方法，配置了请求超时时从此刻开始计算截止时间，不包括在任务队列中等待的时间
+func (r *Request) startDispatch() {
+	if r.timeout > 0 && r.cancel == nil {
+		r.ctx, r.cancel = context.WithTimeout(r.Context(), r.timeout)
+	}
+}
+
+// 将消息体缓冲归还给缓冲池并取消请求的 context，重复调用是安全的
+func (r *Request) Release() {
+	if r.cancel != nil {
+		r.cancel()
+		r.cancel = nil
+	}
+	if r.buf == nil {
+		return
+	}
+	r.msg.SetData(nil)
+	putBuff(r.buf)
+	r.buf = nil
+}
@@ -0,0 +1,129 @@
+package znet
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dokidokikoi/my-zinx/utils"
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+// 将请求的 context 交给测试，wait 为 true 时先等待 context 被取消
+type testCtxRouter struct {
+	BaseRouter
+	wait bool
+	ctxs chan context.Context
+}
+
+func (r *testCtxRouter) Handle(request ziface.IRequest) {
+	ctx := request.Context()
+	if r.wait {
+		<-ctx.Done()
+	}
+	r.ctxs <- ctx
+}
+
+func requestCtx(t *testing.T, router *testCtxRouter) context.Context {
+	t.Helper()
+	select {
+	case ctx := <-router.ctxs:
+		return ctx
+	case <-time.After(3 * time.Second):
+		t.Fatal("router not called")
+		return nil
+	}
+}
+
+func TestRequestContextRelease(t *testing.T) {
+	setTestConfig(t, func(conf *utils.GlobalObj) {
+		conf.RequestTimeout = 10000
+	})
+	s := newTestServer(t)
+	router := &testCtxRouter{ctxs: make(chan context.Context, 1)}
+	s.AddRouter(1, router)
+	client, _ := dialTestServer(t, s)
+
+	// 请求处理完成后 context 被取消
+	writeTestFrame(t, client, s.Codec(), 1, nil)
+	ctx := requestCtx(t, router)
+	waitFor(t, "release", func() bool { return ctx.Err() == context.Canceled })
+}
+
+func TestRequestContextConnClose(t *testing.T) {
+	s := newTestServer(t)
+	router := &testCtxRouter{wait: true, ctxs: make(chan context.Context, 1)}
+	s.AddRouter(1, router)
+	client, conn := dialTestServer(t, s)
+
+	// 客户端断开时正在处理的请求被取消
+	writeTestFrame(t, client, s.Codec(), 1, nil)
+	waitFor(t, "request received", func() bool { return conn.Stats().MsgsIn == 1 })
+	_ = client.Close()
+	if err := requestCtx(t, router).Err(); err != context.Canceled {
+		t.Fatalf("ctx err after close = %v, want context.Canceled", err)
+	}
+}
+
+func TestRequestContextTimeout(t *testing.T) {
+	setTestConfig(t, func(conf *utils.GlobalObj) {
+		conf.RequestTimeout = 50
+	})
+	s := newTestServer(t)
+	router := &testCtxRouter{wait: true, ctxs: make(chan context.Context, 1)}
+	s.AddRouter(1, router)
+	client, conn := dialTestServer(t, s)
+
+	// 超时后请求被取消，连接不受影响
+	writeTestFrame(t, client, s.Codec(), 1, nil)
+	if err := requestCtx(t, router).Err(); err != context.DeadlineExceeded {
+		t.Fatalf("ctx err = %v, want context.DeadlineExceeded", err)
+	}
+	if connClosing(conn) {
+		t.Fatal("conn closed by request timeout")
+	}
+}
+
+// 记录开始处理时请求剩余的处理时间
+type testDeadlineRouter struct {
+	BaseRouter
+	left chan time.Duration
+}
+
+func (r *testDeadlineRouter) Handle(request ziface.IRequest) {
+	deadline, _ := request.Context().Deadline()
+	r.left <- time.Until(deadline)
+}
+
+// 处理前先等待一段时间
+type testSlowRouter struct {
+	BaseRouter
+}
+
+func (r *testSlowRouter) Handle(request ziface.IRequest) {
+	time.Sleep(200 * time.Millisecond)
+}
+
+func TestRequestContextQueueWait(t *testing.T) {
+	setTestConfig(t, func(conf *utils.GlobalObj) {
+		conf.WorkerPoolSize = 1
+		conf.RequestTimeout = 100
+	})
+	s := newTestServer(t)
+	router := &testDeadlineRouter{left: make(chan time.Duration, 1)}
+	s.AddRouter(1, router)
+	s.AddRouter(2, &testSlowRouter{})
+	client, _ := dialTestServer(t, s)
+
+	// 在任务队列中等待前一个请求的时间不计入请求超时
+	writeTestFrame(t, client, s.Codec(), 2, nil)
+	writeTestFrame(t, client, s.Codec(), 1, nil)
+	select {
+	case left := <-router.left:
+		if left < 50*time.Millisecond {
+			t.Fatalf("request time left = %v after queue wait, want about 100ms", left)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("router not called")
+	}
+}
@@ -0,0 +1,429 @@
+package znet
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dokidokikoi/my-zinx/utils"
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+// 会话恢复的结果状态，位于恢复结果消息的第一个字节
+// 补发消息之后绑定用户失败时，客户端会在成功结果之后再收到一条失败结果
+const (
+	ResumeOK     byte = 0
+	ResumeFailed byte = 1
+)
+
+var (
+	// 恢复令牌不存在或已过期
+	ErrResumeTokenInvalid = errors.New("resume token invalid or expired")
+	// 恢复请求格式错误
+	ErrResumeRequestInvalid = errors.New("resume request invalid")
+	// 会话已在新的连接上恢复，旧连接被关闭时的原因说明
+	ErrResumedElsewhere = errors.New("session resumed elsewhere")
+	// 连接没有嵌入 *Connection，无法记录重放缓冲
+	errReplayUnsupported = errors.New("connection does not support replay")
+)
+
+// 一个可恢复的会话
+type resumeSession struct {
+	token string
+	user  string
+	// 重放缓冲，从会话存储恢复的会话在客户端恢复时才创建
+	buf *ReplayBuffer
+	// 当前绑定的连接，断开后为 nil
+	conn ziface.IConnection
+	// 断开时保存的连接属性，恢复时设置到新的连接上
+	properties map[string]interface{}
+	// 断开后的过期定时器
+	expire *time.Timer
+	// 停止将连接属性同步到会话存储
+	unwatch func()
+}
+
+// 会话恢复管理
+// 用户绑定到连接时向客户端下发恢复令牌，并为之后写出的每条消息编号、保存到重放缓冲中；
+// 连接断开后在 ResumeTTL 内保留会话，客户端携带令牌和最后收到的序号重连时，
+// 恢复连接属性和用户绑定，并补发序号之后的全部消息。
+//
+// 序号不写在消息中，由客户端自行计数:
+// 收到恢复令牌之后的第一条消息序号为 1，之后每收到一条消息序号加 1；
+// 恢复成功后补发的第一条消息序号为请求中的 lastSeq+1，之后继续计数。
+// 下发令牌、恢复结果、关闭帧等控制消息不参与编号。
+// 设置了会话存储时连接属性会同步到存储中，进程重启后客户端仍可以恢复会话和连接属性，
+// 但重启前未确认的消息无法补发
+type ResumeManager struct {
+	server ziface.IServer
+	// 同步连接属性的会话存储，可以为 nil
+	store ziface.ISessionStore
+	// 恢复令牌 -> 会话
+	sessions map[string]*resumeSession
+	// ConnID -> 当前绑定的会话
+	conns map[uint32]*resumeSession
+	// 正在恢复会话的连接，恢复过程中的用户绑定不再下发新的令牌
+	resuming map[uint32]bool
+	lock     sync.Mutex
+}
+
+// 生成随机的恢复令牌
+func newResumeToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// 用户绑定到连接，创建可恢复的会话并下发令牌
+func (rm *ResumeManager) sessionBound(user string, conn ziface.IConnection) {
+	rm.lock.Lock()
+	if _, ok := rm.conns[conn.GetConnID()]; ok || rm.resuming[conn.GetConnID()] {
+		rm.lock.Unlock()
+		return
+	}
+	sess := &resumeSession{
+		token: newResumeToken(),
+		user:  user,
+		buf:   NewReplayBuffer(int(utils.GlobalObject.ResumeReplayLen)),
+	}
+	rm.sessions[sess.token] = sess
+	rm.attach(sess, conn, conn.GetProperties())
+	rm.lock.Unlock()
+
+	frame, err := packMessage(rm.server.Codec(), utils.GlobalObject.ResumeTokenMsgID, []byte(sess.token))
+	if err == nil {
+		err = startReplay(conn, sess.buf, frame)
+	}
+	if err != nil {
+		rm.discard(sess)
+	}
+}
+
+// 用户与连接解除绑定
+// 连接断开时保留会话等待恢复，主动解除绑定或被踢下线时丢弃会话
+func (rm *ResumeManager) sessionUnbound(user string, conn ziface.IConnection) {
+	rm.lock.Lock()
+	defer rm.lock.Unlock()
+
+	sess, ok := rm.conns[conn.GetConnID()]
+	if !ok {
+		return
+	}
+	rm.release(sess, conn)
+
+	switch conn.CloseReason().Code {
+	case ziface.CloseNone, ziface.CloseKicked:
+		rm.remove(sess)
+		return
+	}
+	rm.detach(sess, conn)
+}
+
+// 将会话绑定到连接，并开始将连接属性同步到会话存储，调用方需持有 lock
+func (rm *ResumeManager) attach(sess *resumeSession, conn ziface.IConnection, properties map[string]interface{}) {
+	sess.conn = conn
+	rm.conns[conn.GetConnID()] = sess
+	if rm.store == nil {
+		return
+	}
+
+	rm.save(sess, properties, time.Time{})
+	token := sess.token
+	sess.unwatch = conn.WatchProperty("", func(_ ziface.IConnection, change ziface.PropertyChange) {
+		var err error
+		if change.NewExists {
+			err = rm.store.SetProperty(token, change.Key, change.New)
+		} else {
+			err = rm.store.RemoveProperty(token, change.Key)
+		}
+		if err != nil {
+			fmt.Println("sync session property err", change.Key, err)
+		}
+	})
+}
+
+// 将会话从连接上解除，停止记录重放缓冲和同步连接属性，调用方需持有 lock
+func (rm *ResumeManager) release(sess *resumeSession, conn ziface.IConnection) {
+	delete(rm.conns, conn.GetConnID())
+	stopReplay(conn)
+	if sess.unwatch != nil {
+		sess.unwatch()
+		sess.unwatch = nil
+	}
+	sess.conn = nil
+}
+
+// 会话与连接分离，开始计算过期时间，调用方需持有 lock
+func (rm *ResumeManager) detach(sess *resumeSession, conn ziface.IConnection) {
+	sess.properties = conn.GetProperties()
+	if rm.store != nil {
+		rm.save(sess, sess.properties, time.Now())
+	}
+	rm.expireAfter(sess, time.Duration(utils.GlobalObject.ResumeTTL)*time.Millisecond)
+}
+
+// ttl 之后会话仍未恢复时丢弃会话
+func (rm *ResumeManager) expireAfter(sess *resumeSession, ttl time.Duration) {
+	sess.expire = time.AfterFunc(ttl, func() {
+		rm.lock.Lock()
+		defer rm.lock.Unlock()
+		if sess.conn == nil && rm.sessions[sess.token] == sess {
+			rm.remove(sess)
+		}
+	})
+}
+
+// 将会话保存到会话存储
+func (rm *ResumeManager) save(sess *resumeSession, properties map[string]interface{}, detachedAt time.Time) {
+	data := &ziface.SessionData{User: sess.user, Properties: properties, DetachedAt: detachedAt}
+	if err := rm.store.Save(sess.token, data); err != nil {
+		fmt.Println("save session err", sess.user, err)
+	}
+}
+
+// 删除会话，调用方需持有 lock
+func (rm *ResumeManager) remove(sess *resumeSession) {
+	delete(rm.sessions, sess.token)
+	if rm.store == nil {
+		return
+	}
+	if err := rm.store.Delete(sess.token); err != nil {
+		fmt.Println("delete session err", sess.user, err)
+	}
+}
+
+// 丢弃会话
+func (rm *ResumeManager) discard(sess *resumeSession) {
+	rm.lock.Lock()
+	defer rm.lock.Unlock()
+
+	if sess.conn != nil && rm.conns[sess.conn.GetConnID()] == sess {
+		rm.release(sess, sess.conn)
+	}
+	rm.remove(sess)
+}
+
+// 获取需要补发的消息，调用方需持有 lock
+func (rm *ResumeManager) replayFrames(sess *resumeSession, lastSeq uint64) ([][]byte, error) {
+	// 从会话存储恢复的会话没有可以补发的消息，从客户端的序号继续编号
+	if sess.buf == nil {
+		sess.buf = newReplayBufferFrom(int(utils.GlobalObject.ResumeReplayLen), lastSeq)
+		return nil, nil
+	}
+	frames, err := sess.buf.Since(lastSeq)
+	if err != nil {
+		return nil, err
+	}
+	sess.buf.Ack(lastSeq)
+	return frames, nil
+}
+
+// 在新的连接上恢复会话，补发 lastSeq 之后的消息
+func (rm *ResumeManager) Resume(conn ziface.IConnection, token string, lastSeq uint64) error {
+	rm.lock.Lock()
+	sess, ok := rm.sessions[token]
+	if !ok {
+		rm.lock.Unlock()
+		return ErrResumeTokenInvalid
+	}
+
+	// 旧连接可能还没有发现断线，先将会话从旧连接上分离
+	old := sess.conn
+	if old != nil {
+		// 旧连接的写操作可能阻塞在已断开的 socket 上并持有写锁，让它立即失败
+		if tcpConn := old.GetTCPConnection(); tcpConn != nil {
+			_ = tcpConn.SetWriteDeadline(time.Now())
+		}
+		sess.properties = old.GetProperties()
+		rm.release(sess, old)
+	}
+	if sess.expire != nil {
+		sess.expire.Stop()
+	}
+
+	frames, err := rm.replayFrames(sess, lastSeq)
+	if err != nil {
+		rm.remove(sess)
+		rm.lock.Unlock()
+		if old != nil {
+			old.StopWithReason(ziface.CloseKicked, ErrResumedElsewhere)
+		}
+		return err
+	}
+	properties := sess.properties
+	rm.attach(sess, conn, properties)
+	rm.resuming[conn.GetConnID()] = true
+	rm.lock.Unlock()
+
+	sessionMgr := rm.server.GetSessionMgr()
+	if old != nil {
+		sessionMgr.Unbind(old)
+		old.StopWithReason(ziface.CloseKicked, ErrResumedElsewhere)
+	}
+	for key, value := range properties {
+		conn.SetProperty(key, value)
+	}
+
+	// 先补发消息再绑定用户，绑定时其他模块(如离线消息)发送的消息排在补发的消息之后
+	result, err := packMessage(rm.server.Codec(), utils.GlobalObject.ResumeMsgID, []byte{ResumeOK})
+	if err == nil {
+		err = startReplay(conn, sess.buf, append([][]byte{result}, frames...)...)
+	}
+	if err == nil {
+		if err = sessionMgr.Bind(sess.user, conn); err != nil {
+			stopReplay(conn)
+		}
+	}
+
+	rm.lock.Lock()
+	delete(rm.resuming, conn.GetConnID())
+	rm.lock.Unlock()
+	if err != nil {
+		rm.discard(sess)
+	}
+	return err
+}
+
+// 通过内部的 *Connection 写出 frames 并开始记录重放缓冲
+func startReplay(conn ziface.IConnection, buf ziface.IReplayBuffer, frames ...[]byte) error {
+	base, ok := baseConnOf(conn)
+	if !ok {
+		return errReplayUnsupported
+	}
+	return base.startReplay(buf, frames...)
+}
+
+// 停止记录连接的重放缓冲
+func stopReplay(conn ziface.IConnection) {
+	if base, ok := baseConnOf(conn); ok {
+		base.stopReplay()
+	}
+}
+
+// 客户端确认已收到 seq 及之前的消息
+func (rm *ResumeManager) Ack(conn ziface.IConnection, seq uint64) {
+	rm.lock.Lock()
+	sess, ok := rm.conns[conn.GetConnID()]
+	rm.lock.Unlock()
+
+	if ok {
+		sess.buf.Ack(seq)
+	}
+}
+
+// 获取连接当前会话的恢复令牌
+func (rm *ResumeManager) Token(conn ziface.IConnection) (string, bool) {
+	rm.lock.Lock()
+	defer rm.lock.Unlock()
+
+	sess, ok := rm.conns[conn.GetConnID()]
+	if !ok {
+		return "", false
+	}
+	return sess.token, true
+}
+
+// 停止全部会话的过期定时器，服务器停止后不再从会话存储中删除会话，
+// 下次启动时从存储恢复的会话按保存的分离时间继续计算过期
+func (rm *ResumeManager) stop() {
+	rm.lock.Lock()
+	defer rm.lock.Unlock()
+
+	for _, sess := range rm.sessions {
+		if sess.expire != nil {
+			sess.expire.Stop()
+		}
+	}
+}
+
+// 从会话存储恢复进程重启前的会话
+func (rm *ResumeManager) restore() {
+	ttl := time.Duration(utils.GlobalObject.ResumeTTL) * time.Millisecond
+	now := time.Now()
+	err := rm.store.Range(func(token string, data *ziface.SessionData) bool {
+		sess := &resumeSession{token: token, user: data.User, properties: data.Properties}
+		// 进程退出时仍绑定在连接上的会话从现在开始计算过期时间
+		if data.DetachedAt.IsZero() {
+			rm.save(sess, data.Properties, now)
+			data.DetachedAt = now
+		}
+		remaining := ttl - now.Sub(data.DetachedAt)
+		if remaining <= 0 {
+			rm.remove(sess)
+			return true
+		}
+		rm.sessions[token] = sess
+		rm.expireAfter(sess, remaining)
+		return true
+	})
+	if err != nil {
+		fmt.Println("restore sessions err", err)
+	}
+}
+
+// 创建会话恢复管理，store 不为 nil 时同步连接属性并恢复存储中的会话
+func NewResumeManager(server ziface.IServer, store ziface.ISessionStore) *ResumeManager {
+	rm := &ResumeManager{
+		server:   server,
+		store:    store,
+		sessions: make(map[string]*resumeSession),
+		conns:    make(map[uint32]*resumeSession),
+		resuming: make(map[uint32]bool),
+	}
+	if store != nil {
+		rm.restore()
+	}
+	return rm
+}
+
+// 处理客户端的会话恢复请求
+type resumeRouter struct {
+	BaseRouter
+	rm *ResumeManager
+}
+
+func (r *resumeRouter) Handle(request ziface.IRequest) {
+	token, lastSeq, err := ParseResumeRequest(request.GetData())
+	if err == nil {
+		err = r.rm.Resume(request.GetConnection(), token, lastSeq)
+	}
+	if err != nil {
+		_ = request.GetConnection().SendMsg(utils.GlobalObject.ResumeMsgID, append([]byte{ResumeFailed}, err.Error()...))
+	}
+}
+
+// 处理客户端的消息确认
+type resumeAckRouter struct {
+	BaseRouter
+	rm *ResumeManager
+}
+
+func (r *resumeAckRouter) Handle(request ziface.IRequest) {
+	if len(request.GetData()) < 8 {
+		return
+	}
+	r.rm.Ack(request.GetConnection(), binary.LittleEndian.Uint64(request.GetData()))
+}
+
+// 构造会话恢复请求的消息内容: 最后收到的序号 uint64(8字节，小端序) + 恢复令牌
+func PackResumeRequest(token string, lastSeq uint64) []byte {
+	data := binary.LittleEndian.AppendUint64(make([]byte, 0, 8+len(token)), lastSeq)
+	return append(data, token...)
+}
+
+// 解析会话恢复请求的消息内容
+func ParseResumeRequest(data []byte) (string, uint64, error) {
+	if len(data) <= 8 {
+		return "", 0, ErrResumeRequestInvalid
+	}
+	return string(data[8:]), binary.LittleEndian.Uint64(data[:8]), nil
+}
+
+// 构造消息确认的消息内容: 已收到的序号 uint64(8字节，小端序)，序号的计算方式见 ResumeManager
+func PackResumeAck(seq uint64) []byte {
+	return binary.LittleEndian.AppendUint64(make([]byte, 0, 8), seq)
+}
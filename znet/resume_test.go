@@ -0,0 +1,194 @@
+package znet
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dokidokikoi/my-zinx/utils"
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+// 将消息体作为用户名绑定到连接
+type testLoginRouter struct {
+	BaseRouter
+	server ziface.IServer
+}
+
+func (r *testLoginRouter) Handle(request ziface.IRequest) {
+	conn := request.GetConnection()
+	conn.SetProperty("nick", "al")
+	_ = r.server.GetSessionMgr().Bind(string(request.GetData()), conn)
+}
+
+const testLoginMsgID = 1
+
+// 登录并读取恢复令牌
+func loginTestClient(t *testing.T, s *Server, user string) (net.Conn, *bufio.Reader, ziface.IConnection, string) {
+	t.Helper()
+	client, conn := dialTestServer(t, s)
+	r := bufio.NewReader(client)
+	writeTestFrame(t, client, s.Codec(), testLoginMsgID, []byte(user))
+	msgID, token := readTestFrame(t, client, r, s.Codec())
+	if msgID != utils.GlobalObject.ResumeTokenMsgID || token == "" {
+		t.Fatalf("login got msg %d %q, want resume token", msgID, token)
+	}
+	return client, r, conn, token
+}
+
+func TestResumeManager(t *testing.T) {
+	setTestConfig(t, func(conf *utils.GlobalObj) {
+		conf.ResumeEnable = true
+		conf.ResumeTTL = 300
+	})
+	s := newTestServer(t)
+	s.AddRouter(testLoginMsgID, &testLoginRouter{server: s})
+	codec := s.Codec()
+
+	c1, r1, conn1, token := loginTestClient(t, s, "alice")
+	for _, data := range []string{"m1", "m2", "m3"} {
+		if err := conn1.SendBuffMsg(10, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 客户端从令牌之后开始计数，收到 m1 后确认序号 1
+	if _, data := readTestFrame(t, c1, r1, codec); data != "m1" {
+		t.Fatalf("first msg = %q, want m1", data)
+	}
+	writeTestFrame(t, c1, codec, utils.GlobalObject.ResumeAckMsgID, PackResumeAck(1))
+	waitFor(t, "ack", func() bool {
+		_, err := s.resumeMgr.sessions[token].buf.Since(0)
+		return err != nil
+	})
+	if frames, err := s.resumeMgr.sessions[token].buf.Since(1); err != nil || len(frames) != 2 {
+		t.Fatalf("unacked frames = %d, %v, want 2", len(frames), err)
+	}
+
+	_ = c1.Close()
+	waitFor(t, "conn 1 closed", func() bool { return connClosing(conn1) && s.GetSessionMgr().Len() == 0 })
+
+	// 新连接携带 lastSeq=1 恢复，补发 m2、m3，之后的消息继续编号
+	c2, conn2 := dialTestServer(t, s)
+	r2 := bufio.NewReader(c2)
+	writeTestFrame(t, c2, codec, utils.GlobalObject.ResumeMsgID, PackResumeRequest(token, 1))
+	if msgID, data := readTestFrame(t, c2, r2, codec); msgID != utils.GlobalObject.ResumeMsgID || data != string([]byte{ResumeOK}) {
+		t.Fatalf("resume result = %d %q", msgID, data)
+	}
+	for _, want := range []string{"m2", "m3"} {
+		if _, data := readTestFrame(t, c2, r2, codec); data != want {
+			t.Fatalf("replayed %q, want %q", data, want)
+		}
+	}
+	waitFor(t, "rebind", func() bool { return len(s.GetSessionMgr().GetByUser("alice")) == 1 })
+	if nick, _ := conn2.GetProperty("nick"); nick != "al" {
+		t.Fatalf("restored property nick = %v", nick)
+	}
+	_ = conn2.SendBuffMsg(10, []byte("m4"))
+	if _, data := readTestFrame(t, c2, r2, codec); data != "m4" {
+		t.Fatalf("msg after resume = %q, want m4", data)
+	}
+	if seq := s.resumeMgr.sessions[token].buf.LastSeq(); seq != 4 {
+		t.Fatalf("last seq = %d, want 4", seq)
+	}
+
+	// 会话过期后无法恢复
+	_ = c2.Close()
+	waitFor(t, "session expired", func() bool {
+		s.resumeMgr.lock.Lock()
+		defer s.resumeMgr.lock.Unlock()
+		return len(s.resumeMgr.sessions) == 0
+	})
+	c3, _ := dialTestServer(t, s)
+	r3 := bufio.NewReader(c3)
+	writeTestFrame(t, c3, codec, utils.GlobalObject.ResumeMsgID, PackResumeRequest(token, 4))
+	msgID, data := readTestFrame(t, c3, r3, codec)
+	if msgID != utils.GlobalObject.ResumeMsgID || data[0] != ResumeFailed || !strings.Contains(data, ErrResumeTokenInvalid.Error()) {
+		t.Fatalf("expired resume result = %d %q", msgID, data)
+	}
+}
+
+func TestResumeStalledOldConn(t *testing.T) {
+	setTestConfig(t, func(conf *utils.GlobalObj) {
+		conf.ResumeEnable = true
+		conf.ResumeReplayLen = 1 << 20
+	})
+	s := newTestServer(t)
+	s.AddRouter(testLoginMsgID, &testLoginRouter{server: s})
+	codec := s.Codec()
+
+	// 旧连接的客户端不再读取，Writer 阻塞在写 socket 上
+	_, _, conn1, token := loginTestClient(t, s, "alice")
+	payload := make([]byte, 4000)
+	waitFor(t, "old conn writer stalled", func() bool {
+		for conn1.SendBuffMsg(10, payload) == nil {
+		}
+		written := conn1.Stats().BytesOut
+		time.Sleep(50 * time.Millisecond)
+		return conn1.Stats().BytesOut == written
+	})
+
+	c2, _ := dialTestServer(t, s)
+	r2 := bufio.NewReader(c2)
+	writeTestFrame(t, c2, codec, utils.GlobalObject.ResumeMsgID, PackResumeRequest(token, 0))
+	if msgID, data := readTestFrame(t, c2, r2, codec); msgID != utils.GlobalObject.ResumeMsgID || data[0] != ResumeOK {
+		t.Fatalf("resume result = %d %q", msgID, data)
+	}
+	if code := conn1.CloseReason().Code; code == ziface.CloseNone {
+		t.Fatal("old conn not closed after resume")
+	}
+}
+
+func TestResumeAfterRestart(t *testing.T) {
+	setTestConfig(t, func(conf *utils.GlobalObj) {
+		conf.ResumeEnable = true
+		conf.SessionStore = SessionStoreFile
+		conf.SessionStoreDir = t.TempDir()
+	})
+	s1 := newTestServer(t)
+	s1.AddRouter(testLoginMsgID, &testLoginRouter{server: s1})
+	_, _, _, token := loginTestClient(t, s1, "alice")
+
+	// 停止时关闭会话存储，同一目录可以被新的 Server 打开
+	s1.Stop()
+	if err := s1.sessionStore.Save("closed", &ziface.SessionData{}); err != os.ErrClosed {
+		t.Fatalf("save after stop err = %v, want os.ErrClosed", err)
+	}
+
+	s2 := newTestServer(t)
+	c2, conn2 := dialTestServer(t, s2)
+	r2 := bufio.NewReader(c2)
+	writeTestFrame(t, c2, s2.Codec(), utils.GlobalObject.ResumeMsgID, PackResumeRequest(token, 0))
+	if msgID, data := readTestFrame(t, c2, r2, s2.Codec()); msgID != utils.GlobalObject.ResumeMsgID || data[0] != ResumeOK {
+		t.Fatalf("resume after restart = %d %q", msgID, data)
+	}
+	waitFor(t, "rebind", func() bool { return len(s2.GetSessionMgr().GetByUser("alice")) == 1 })
+	if nick, _ := conn2.GetProperty("nick"); nick != "al" {
+		t.Fatalf("restored property nick = %v", nick)
+	}
+}
+
+func TestResumeDecoratedConn(t *testing.T) {
+	setTestConfig(t, func(conf *utils.GlobalObj) {
+		conf.ResumeEnable = true
+	})
+	s := newTestServer(t, WithConnDecorator(func(conn ziface.IConnection) ziface.IConnection {
+		return &prefixSendConn{IConnection: conn}
+	}))
+	s.AddRouter(testLoginMsgID, &testLoginRouter{server: s})
+
+	// 装饰器包装后的连接同样可以记录重放缓冲
+	c1, r1, conn1, _ := loginTestClient(t, s, "alice")
+	if err := conn1.SendBuffMsg(10, []byte("m1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, data := readTestFrame(t, c1, r1, s.Codec()); data != "m1" {
+		t.Fatalf("msg = %q, want m1", data)
+	}
+	token, _ := s.resumeMgr.Token(conn1)
+	if seq := s.resumeMgr.sessions[token].buf.LastSeq(); seq != 1 {
+		t.Fatalf("last seq = %d, want 1", seq)
+	}
+}
@@ -0,0 +1,76 @@
+package znet
+
+import (
+	"sync"
+
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+// 连接级别的串行执行器
+// 保证同一个连接的请求按到达顺序依次处理，不同连接之间仍然并行
+// 有请求时才启动处理 Goroutine，队列为空时 Goroutine 立即退出
+type serialExecutor struct {
+	msgHandler ziface.IMsgHandler
+	// 待处理的请求队列
+	queue []ziface.IRequest
+	// 已提交但还没有处理完的请求，达到容量时 Submit 阻塞
+	slots chan struct{}
+	// 当前是否有 Goroutine 在处理队列
+	running bool
+	lock    sync.Mutex
+}
+
+// maxLen 为同时待处理的最大请求数，小于 1 时按 1 处理
+func newSerialExecutor(msgHandler ziface.IMsgHandler, maxLen int) *serialExecutor {
+	if maxLen < 1 {
+		maxLen = 1
+	}
+	return &serialExecutor{
+		msgHandler: msgHandler,
+		slots:      make(chan struct{}, maxLen),
+	}
+}
+
+// 提交一个请求，如果当前没有处理中的 Goroutine 则启动一个
+// 待处理的请求达到上限时阻塞，使读取端暂停读取，直到有请求处理完成；
+// 阻塞期间 done 被关闭时返回 false，请求不会被处理
+func (e *serialExecutor) Submit(request ziface.IRequest, done <-chan struct{}) bool {
+	select {
+	case e.slots <- struct{}{}:
+	case <-done:
+		return false
+	}
+
+	e.lock.Lock()
+	e.queue = append(e.queue, request)
+	if e.running {
+		e.lock.Unlock()
+		return true
+	}
+	e.running = true
+	e.lock.Unlock()
+
+	go e.run()
+	return true
+}
+
+// 依次处理队列中的请求，直到队列为空
+func (e *serialExecutor) run() {
+	for {
+		e.lock.Lock()
+		if len(e.queue) == 0 {
+			e.running = false
+			e.lock.Unlock()
+			return
+		}
+		// 取出当前队列中的全部请求，处理期间新的请求继续追加到新队列
+		batch := e.queue
+		e.queue = nil
+		e.lock.Unlock()
+
+		for _, req := range batch {
+			e.msgHandler.DoMsgHandler(req)
+			<-e.slots
+		}
+	}
+}
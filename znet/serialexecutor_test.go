@@ -0,0 +1,97 @@
+package znet
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+type orderRouter struct {
+	BaseRouter
+	lock sync.Mutex
+	ids  []uint32
+	wg   *sync.WaitGroup
+}
+
+func (r *orderRouter) Handle(req ziface.IRequest) {
+	// 让先到的请求处理得更慢，检验是否仍然按顺序执行
+	if req.GetMsgID()%2 == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	r.lock.Lock()
+	r.ids = append(r.ids, req.GetMsgID())
+	r.lock.Unlock()
+	r.wg.Done()
+}
+
+func TestSerialExecutorOrder(t *testing.T) {
+	const total = 50
+	router := &orderRouter{wg: &sync.WaitGroup{}}
+	mh := &MsgHandler{Apis: make(map[uint32]ziface.IRouter)}
+	for i := uint32(0); i < total; i++ {
+		mh.Apis[i] = router
+	}
+
+	e := newSerialExecutor(mh, total)
+	router.wg.Add(total)
+	for i := uint32(0); i < total; i++ {
+		e.Submit(&Request{msg: NewMessage(i, nil)}, nil)
+	}
+	router.wg.Wait()
+
+	for i, id := range router.ids {
+		if id != uint32(i) {
+			t.Fatalf("request %d handled at position %d", id, i)
+		}
+	}
+}
+
+// 阻塞到 release 被关闭的路由
+type blockRouter struct {
+	BaseRouter
+	release chan struct{}
+}
+
+func (r *blockRouter) Handle(req ziface.IRequest) {
+	<-r.release
+}
+
+func TestSerialExecutorBackpressure(t *testing.T) {
+	router := &blockRouter{release: make(chan struct{})}
+	mh := &MsgHandler{Apis: map[uint32]ziface.IRouter{1: router}}
+	e := newSerialExecutor(mh, 2)
+	for i := 0; i < 2; i++ {
+		if !e.Submit(&Request{msg: NewMessage(1, nil)}, nil) {
+			t.Fatalf("submit %d failed", i)
+		}
+	}
+
+	// 待处理的请求达到上限，提交阻塞到有请求处理完成
+	submitted := make(chan bool)
+	go func() { submitted <- e.Submit(&Request{msg: NewMessage(1, nil)}, nil) }()
+	select {
+	case <-submitted:
+		t.Fatal("submit over the limit did not block")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(router.release)
+	if !<-submitted {
+		t.Fatal("blocked submit failed after release")
+	}
+
+	// 阻塞期间连接关闭时放弃提交
+	router2 := &blockRouter{release: make(chan struct{})}
+	defer close(router2.release)
+	e = newSerialExecutor(&MsgHandler{Apis: map[uint32]ziface.IRouter{1: router2}}, 1)
+	e.Submit(&Request{msg: NewMessage(1, nil)}, nil)
+	done := make(chan struct{})
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(done)
+	}()
+	if e.Submit(&Request{msg: NewMessage(1, nil)}, done) {
+		t.Fatal("submit after done should fail")
+	}
+}
@@ -1,6 +1,7 @@
 package znet
 
 import (
+	"errors"
 	"fmt"
 	"net"
 
@@ -22,16 +23,56 @@ type Server struct {
 	msgHandler ziface.IMsgHandler
 	// 当前 Server 的连接管理器
 	ConnMgr ziface.IConnManager
+	// 当前 Server 的分组管理器
+	GroupMgr ziface.IGroupManager
+	// 当前 Server 的会话管理器
+	SessionMgr ziface.ISessionManager
 
 	onConnStart func(conn ziface.IConnection)
 	onConnStop  func(conn ziface.IConnection)
+	onRateLimit func(conn ziface.IConnection, event ziface.RateLimitEvent)
+	onConnEvict func(conn ziface.IConnection)
 
-	packet ziface.IPacket
+	// 连接收发消息使用的编解码器
+	codec ziface.IFrameCodec
+
+	// 会话恢复管理，未开启会话恢复时为 nil
+	resumeMgr *ResumeManager
+	// 离线消息箱，未开启离线消息时为 nil
+	outbox      *Outbox
+	outboxStore ziface.IOutboxStore
+	// 会话恢复时同步连接属性的会话存储
+	sessionStore ziface.ISessionStore
+	// 集群总线，未开启集群时为 nil
+	cluster     *Cluster
+	clusterConf *clusterConfig
+	// 连接数达到上限时选出被踢掉的连接
+	evictions *evictionTracker
+
+	// 自定义的连接工厂和装饰器
+	connFactory    ConnFactory
+	connDecorators []ConnDecorator
+}
+
+// 嵌入 *Connection 的连接类型都实现了该接口
+type connectionBase interface {
+	baseConnection() *Connection
 }
 
 func (s *Server) Start() {
 	fmt.Printf("[START] Server listener at IP: %s, Port %d, is starting\n", s.IP, s.Port)
 
+	// 定期清理过期的离线消息
+	if s.outbox != nil {
+		s.outbox.start(outboxQuotaFromConfig().TTL / 2)
+	}
+	// 开启集群总线
+	if s.cluster != nil {
+		if err := s.cluster.Start(); err != nil {
+			fmt.Println("start cluster err", err)
+		}
+	}
+
 	// 开启一个 go 去做服务器的 listener 业务
 	go func() {
 		// 启动 worker 工作池机制
@@ -66,15 +107,20 @@ func (s *Server) Start() {
 			}
 
 			// 3.2 设置服务器最大连接控制，
-			// 如果超过最大连，则关闭新的连接
-			if s.ConnMgr.Len() >= utils.GlobalObject.MaxConn {
+			// 如果超过最大连，按 MaxConnPolicy 踢掉旧连接或关闭新的连接
+			if s.ConnMgr.Len() >= utils.GlobalObject.MaxConn && !s.evictForNewConn() {
 				conn.Close()
 				continue
 			}
 
 			// 3.3 处理该新连接请求的业务方法，
 			// 此时 handler 和 conn 应该是绑定的
-			dealConn := NewConnection(s, conn, cid, s.msgHandler)
+			dealConn, err := s.newConnection(conn, cid)
+			if err != nil {
+				fmt.Println("create connection err", err)
+				conn.Close()
+				continue
+			}
 			cid++
 
 			// 3.4 启动当前连接的处理业务
@@ -83,11 +129,49 @@ func (s *Server) Start() {
 	}()
 }
 
+// 创建连接，依次应用连接工厂和装饰器，然后将连接添加到连接管理中
+func (s *Server) newConnection(conn *net.TCPConn, connID uint32) (ziface.IConnection, error) {
+	var dealConn ziface.IConnection
+	if s.connFactory != nil {
+		dealConn = s.connFactory(s, conn, connID, s.msgHandler)
+	} else {
+		dealConn = NewBaseConnection(s, conn, connID, s.msgHandler)
+	}
+
+	base, ok := dealConn.(connectionBase)
+	if !ok {
+		return nil, errors.New("connection factory must return a type embedding *znet.Connection")
+	}
+	for _, decorator := range s.connDecorators {
+		dealConn = decorator(dealConn)
+	}
+	// 让内部的 Connection 对外使用包装后的连接
+	base.baseConnection().owner = dealConn
+
+	s.ConnMgr.Add(dealConn)
+	s.evictions.add(dealConn)
+	return dealConn, nil
+}
+
 func (s *Server) Stop() {
 	fmt.Println("[STOP] Zinx server, name", s.Name)
 
 	// 将需要清理的连接信息或者其他信息一并停止或者清理
 	s.ConnMgr.ClearConn()
+	if s.outbox != nil {
+		s.outbox.stop()
+	}
+	if s.cluster != nil {
+		s.cluster.Stop()
+	}
+	if s.resumeMgr != nil {
+		s.resumeMgr.stop()
+	}
+	if s.sessionStore != nil {
+		if err := s.sessionStore.Close(); err != nil {
+			fmt.Println("close session store err", err)
+		}
+	}
 }
 
 func (s *Server) Serve() {
@@ -108,6 +192,28 @@ func (s *Server) GetConnMgr() ziface.IConnManager {
 	return s.ConnMgr
 }
 
+func (s *Server) GetGroupMgr() ziface.IGroupManager {
+	return s.GroupMgr
+}
+
+func (s *Server) GetSessionMgr() ziface.ISessionManager {
+	return s.SessionMgr
+}
+
+func (s *Server) GetOutbox() ziface.IOutbox {
+	if s.outbox == nil {
+		return nil
+	}
+	return s.outbox
+}
+
+func (s *Server) GetCluster() ziface.ICluster {
+	if s.cluster == nil {
+		return nil
+	}
+	return s.cluster
+}
+
 func (s *Server) SetOnConnStart(hookFunc func(ziface.IConnection)) {
 	s.onConnStart = hookFunc
 }
@@ -124,31 +230,120 @@ func (s *Server) CallOnConnStart(conn ziface.IConnection) {
 }
 
 func (s *Server) CallOnConnStop(conn ziface.IConnection) {
+	s.evictions.remove(conn)
 	if s.onConnStop != nil {
 		fmt.Println("----> CallOnConnStop....")
 		s.onConnStop(conn)
 	}
 }
 
+func (s *Server) SetOnRateLimit(hookFunc func(ziface.IConnection, ziface.RateLimitEvent)) {
+	s.onRateLimit = hookFunc
+}
+
+func (s *Server) CallOnRateLimit(conn ziface.IConnection, event ziface.RateLimitEvent) {
+	if s.onRateLimit != nil {
+		s.onRateLimit(conn, event)
+	}
+}
+
+func (s *Server) SetOnConnEvict(hookFunc func(ziface.IConnection)) {
+	s.onConnEvict = hookFunc
+}
+
+func (s *Server) CallOnConnEvict(conn ziface.IConnection) {
+	if s.onConnEvict != nil {
+		s.onConnEvict(conn)
+	}
+}
+
+// 获取使用的 IPacket，使用 WithCodec 指定了其他编解码器时返回该编解码器的适配，
+// 只能用于封包，Unpack 返回 ErrPacketUnpackUnsupported
+//
+// Deprecated: 使用 Codec 获取连接使用的编解码器
 func (s *Server) Packet() ziface.IPacket {
-	return s.packet
+	if pc, ok := s.codec.(*PacketCodec); ok {
+		return pc.Packet()
+	}
+	return &codecPacket{codec: s.codec}
+}
+
+func (s *Server) Codec() ziface.IFrameCodec {
+	return s.codec
 }
 
 func NewServer(opts ...Option) ziface.IServer {
 	// 先初始化全局配置文件
 	utils.GlobalObject.Reload()
+	sessionMgr := NewSessionManager()
 	s := &Server{
 		Name:       utils.GlobalObject.Name,
 		IPVersion:  "tcp4",
 		IP:         utils.GlobalObject.Host,
 		Port:       utils.GlobalObject.TcpPort,
 		msgHandler: NewMsgHandler(),
-		ConnMgr:    NewConnManager(),
-		packet:     NewDataPack(),
+		ConnMgr:    newConnManagerFromConfig(),
+		GroupMgr:   NewGroupManager(),
+		SessionMgr: sessionMgr,
+		codec:      NewPacketCodec(NewDataPack()),
+		evictions:  newEvictionTracker(),
 	}
+	sessionMgr.addListener(s.evictions)
 
 	for _, opt := range opts {
 		opt(s)
 	}
+	// 广播时与连接使用相同的编解码器
+	s.ConnMgr.SetCodec(s.codec)
+	s.GroupMgr.SetCodec(s.codec)
+
+	// 文本编解码器的未知命令和其他请求一样按顺序处理
+	if _, ok := s.codec.(*TextCodec); ok {
+		s.AddRouter(TextUnknownCommandMsgID, &textUnknownCommandRouter{})
+	}
+
+	// 开启会话恢复时注册恢复请求和消息确认的处理方法
+	if !utils.GlobalObject.ResumeEnable && s.sessionStore != nil {
+		fmt.Println("[WARN] session store is set but ResumeEnable is false, the store is not used")
+	}
+	if utils.GlobalObject.ResumeEnable && !supportsControlFrames(s.codec) {
+		fmt.Printf("codec %T can not carry resume frames, resume is disabled\n", s.codec)
+	} else if utils.GlobalObject.ResumeEnable {
+		if s.sessionStore == nil {
+			store, err := newSessionStoreFromConfig()
+			if err != nil {
+				fmt.Println("create session store err", err)
+			}
+			s.sessionStore = store
+		}
+		s.resumeMgr = NewResumeManager(s, s.sessionStore)
+		sessionMgr.addListener(s.resumeMgr)
+		s.AddRouter(utils.GlobalObject.ResumeMsgID, &resumeRouter{rm: s.resumeMgr})
+		s.AddRouter(utils.GlobalObject.ResumeAckMsgID, &resumeAckRouter{rm: s.resumeMgr})
+	}
+
+	// 开启离线消息或指定了离线消息的存储时创建离线消息箱，
+	// 在会话恢复之后注册，投递的离线消息排在补发的消息之后
+	if utils.GlobalObject.OutboxEnable && s.outboxStore == nil {
+		store, err := newOutboxStoreFromConfig()
+		if err != nil {
+			fmt.Println("create outbox store err", err, ", use memory store")
+			store = NewMemoryOutboxStore(outboxQuotaFromConfig())
+		}
+		s.outboxStore = store
+	}
+	if s.outboxStore != nil {
+		s.outbox = NewOutbox(s.outboxStore, sessionMgr)
+		sessionMgr.addListener(s.outbox)
+	}
+
+	// 配置了集群总线端口时开启集群，用户绑定和解除绑定时通知其他节点
+	if s.clusterConf == nil && utils.GlobalObject.ClusterPort > 0 {
+		s.clusterConf = clusterConfigFromGlobal()
+	}
+	if s.clusterConf != nil {
+		s.cluster = newCluster(s, s.clusterConf)
+		sessionMgr.addListener(s.cluster)
+	}
 	return s
 }
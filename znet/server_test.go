@@ -1,10 +1,15 @@
 package znet
 
 import (
+	"bufio"
 	"fmt"
 	"net"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/dokidokikoi/my-zinx/utils"
+	"github.com/dokidokikoi/my-zinx/ziface"
 )
 
 func ClientTest() {
@@ -48,3 +53,97 @@ func TestServer(t *testing.T) {
 	// 2. 开启服务
 	s.Serve()
 }
+
+// 修改全局配置，测试结束时恢复
+// 需要在创建连接之前调用，保证恢复配置时连接已经全部退出
+func setTestConfig(t *testing.T, update func(conf *utils.GlobalObj)) {
+	t.Helper()
+	old := *utils.GlobalObject
+	t.Cleanup(func() { *utils.GlobalObject = old })
+	update(utils.GlobalObject)
+}
+
+// 创建 Server 并启动工作池，测试结束时停止
+func newTestServer(t *testing.T, opts ...Option) *Server {
+	t.Helper()
+	s := NewServer(opts...).(*Server)
+	s.msgHandler.StartWorkerPool()
+	t.Cleanup(func() {
+		s.Stop()
+		s.msgHandler.(*MsgHandler).StopWorkerPool()
+	})
+	return s
+}
+
+var testConnID atomic.Uint32
+
+// 通过本地回环建立一条到 s 的连接，返回客户端的 socket 和服务端的连接
+// 测试结束时关闭连接，并等待连接的 Goroutine 退出
+func dialTestServer(t *testing.T, s *Server) (net.Conn, ziface.IConnection) {
+	t.Helper()
+	ln, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted, err := ln.AcceptTCP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := s.newConnection(accepted, testConnID.Add(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn.Start()
+	}()
+	t.Cleanup(func() {
+		_ = client.Close()
+		conn.StopWithReason(ziface.CloseServerShutdown, nil)
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Errorf("conn %d did not stop", conn.GetConnID())
+		}
+	})
+	return client, conn
+}
+
+// 客户端使用 codec 发送一条消息
+func writeTestFrame(t *testing.T, client net.Conn, codec ziface.IFrameCodec, msgID uint32, data []byte) {
+	t.Helper()
+	packed, err := packMessage(codec, msgID, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Write(packed); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 客户端使用 codec 读取一条消息
+func readTestFrame(t *testing.T, client net.Conn, r *bufio.Reader, codec ziface.IFrameCodec) (uint32, string) {
+	t.Helper()
+	_ = client.SetReadDeadline(time.Now().Add(3 * time.Second))
+	msg, err := codec.Decode(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg.GetMsgID(), string(msg.GetData())
+}
+
+// 将收到的消息原样发回
+type testEchoRouter struct {
+	BaseRouter
+}
+
+func (r *testEchoRouter) Handle(request ziface.IRequest) {
+	_ = request.GetConnection().SendMsg(request.GetMsgID(), request.GetData())
+}
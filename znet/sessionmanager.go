@@ -0,0 +1,197 @@
+package znet
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/dokidokikoi/my-zinx/utils"
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+// 同一用户重复登录时的处理策略
+const (
+	// 踢掉旧连接
+	DuplicateLoginKickOld = "kick"
+	// 拒绝新连接的绑定
+	DuplicateLoginRejectNew = "reject"
+	// 允许同时绑定多个连接
+	DuplicateLoginAllowMulti = "multi"
+)
+
+var (
+	// 用户已在其他连接登录，且策略为拒绝新连接
+	ErrDuplicateLogin = errors.New("user already logged in")
+	// 旧连接被踢下线时的关闭原因说明
+	ErrLoggedInElsewhere = errors.New("logged in elsewhere")
+)
+
+// 会话绑定状态变化的内部监听者，用于会话恢复等模块
+type sessionListener interface {
+	sessionBound(user string, conn ziface.IConnection)
+	sessionUnbound(user string, conn ziface.IConnection)
+}
+
+// ISessionManager 的实现
+type SessionManager struct {
+	// 用户 -> ConnID -> 连接
+	users map[string]map[uint32]ziface.IConnection
+	// ConnID -> 用户
+	conns map[uint32]string
+	// 保护会话信息的读写锁
+	lock sync.RWMutex
+	// 重复登录的处理策略
+	policy string
+
+	onBind   func(user string, conn ziface.IConnection)
+	onUnbind func(user string, conn ziface.IConnection)
+	// 内部监听者，在创建 Server 时注册
+	listeners []sessionListener
+}
+
+func (sm *SessionManager) Bind(user string, conn ziface.IConnection) error {
+	sm.lock.Lock()
+	// 在锁内检查，保证连接关闭时的 Unbind 一定发生在绑定之后
+	if connClosing(conn) {
+		sm.lock.Unlock()
+		return ErrConnClosed
+	}
+
+	if old, ok := sm.conns[conn.GetConnID()]; ok && old == user {
+		sm.lock.Unlock()
+		return nil
+	}
+
+	var kicked []ziface.IConnection
+	if existing := sm.users[user]; len(existing) > 0 {
+		switch sm.policy {
+		case DuplicateLoginRejectNew:
+			sm.lock.Unlock()
+			return ErrDuplicateLogin
+		case DuplicateLoginAllowMulti:
+		default:
+			for _, old := range existing {
+				kicked = append(kicked, old)
+			}
+		}
+	}
+
+	// 连接之前绑定了其他用户时先解除
+	var unbound []ziface.IConnection
+	prevUser, rebind := sm.conns[conn.GetConnID()]
+	if rebind {
+		sm.unbind(conn.GetConnID())
+		unbound = append(unbound, conn)
+	}
+	for _, old := range kicked {
+		sm.unbind(old.GetConnID())
+	}
+
+	if sm.users[user] == nil {
+		sm.users[user] = make(map[uint32]ziface.IConnection)
+	}
+	sm.users[user][conn.GetConnID()] = conn
+	sm.conns[conn.GetConnID()] = user
+	sm.lock.Unlock()
+
+	// 先踢掉旧连接，解除绑定的通知中可以通过关闭原因区分被踢下线
+	for _, old := range kicked {
+		old.StopWithReason(ziface.CloseKicked, ErrLoggedInElsewhere)
+	}
+	for _, c := range unbound {
+		sm.callOnUnbind(prevUser, c)
+	}
+	for _, old := range kicked {
+		sm.callOnUnbind(user, old)
+	}
+	sm.callOnBind(user, conn)
+	return nil
+}
+
+func (sm *SessionManager) Unbind(conn ziface.IConnection) {
+	sm.lock.Lock()
+	user, ok := sm.conns[conn.GetConnID()]
+	if ok {
+		sm.unbind(conn.GetConnID())
+	}
+	sm.lock.Unlock()
+
+	if ok {
+		sm.callOnUnbind(user, conn)
+	}
+}
+
+func (sm *SessionManager) callOnBind(user string, conn ziface.IConnection) {
+	for _, l := range sm.listeners {
+		l.sessionBound(user, conn)
+	}
+	if sm.onBind != nil {
+		sm.onBind(user, conn)
+	}
+}
+
+func (sm *SessionManager) callOnUnbind(user string, conn ziface.IConnection) {
+	for _, l := range sm.listeners {
+		l.sessionUnbound(user, conn)
+	}
+	if sm.onUnbind != nil {
+		sm.onUnbind(user, conn)
+	}
+}
+
+// 注册内部监听者
+func (sm *SessionManager) addListener(l sessionListener) {
+	sm.listeners = append(sm.listeners, l)
+}
+
+// 解除连接的绑定，调用方需持有写锁
+func (sm *SessionManager) unbind(connID uint32) {
+	user := sm.conns[connID]
+	delete(sm.conns, connID)
+	delete(sm.users[user], connID)
+	if len(sm.users[user]) == 0 {
+		delete(sm.users, user)
+	}
+}
+
+func (sm *SessionManager) GetByUser(user string) []ziface.IConnection {
+	sm.lock.RLock()
+	defer sm.lock.RUnlock()
+
+	conns := make([]ziface.IConnection, 0, len(sm.users[user]))
+	for _, conn := range sm.users[user] {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+func (sm *SessionManager) UserOf(conn ziface.IConnection) (string, bool) {
+	sm.lock.RLock()
+	defer sm.lock.RUnlock()
+
+	user, ok := sm.conns[conn.GetConnID()]
+	return user, ok
+}
+
+func (sm *SessionManager) Len() int {
+	sm.lock.RLock()
+	defer sm.lock.RUnlock()
+
+	return len(sm.users)
+}
+
+func (sm *SessionManager) SetOnBind(hookFunc func(user string, conn ziface.IConnection)) {
+	sm.onBind = hookFunc
+}
+
+func (sm *SessionManager) SetOnUnbind(hookFunc func(user string, conn ziface.IConnection)) {
+	sm.onUnbind = hookFunc
+}
+
+// 创建会话管理器，重复登录的处理策略由全局配置 DuplicateLoginPolicy 决定
+func NewSessionManager() *SessionManager {
+	return &SessionManager{
+		users:  make(map[string]map[uint32]ziface.IConnection),
+		conns:  make(map[uint32]string),
+		policy: utils.GlobalObject.DuplicateLoginPolicy,
+	}
+}
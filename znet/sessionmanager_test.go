@@ -0,0 +1,58 @@
+package znet
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+// 创建一个不带 socket 的连接，用于测试会话管理
+func newTestConnection(connID uint32) *Connection {
+	c := &Connection{ConnID: connID}
+	c.owner = c
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	return c
+}
+
+func TestSessionManagerPolicy(t *testing.T) {
+	for _, policy := range []string{DuplicateLoginKickOld, DuplicateLoginRejectNew, DuplicateLoginAllowMulti} {
+		t.Run(policy, func(t *testing.T) {
+			sm := NewSessionManager()
+			sm.policy = policy
+			c1, c2 := newTestConnection(1), newTestConnection(2)
+
+			if err := sm.Bind("alice", c1); err != nil {
+				t.Fatal(err)
+			}
+			err := sm.Bind("alice", c2)
+
+			switch policy {
+			case DuplicateLoginKickOld:
+				if err != nil || c1.CloseReason().Code != ziface.CloseKicked {
+					t.Fatalf("err = %v, old conn reason = %v", err, c1.CloseReason())
+				}
+				if conns := sm.GetByUser("alice"); len(conns) != 1 || conns[0] != c2 {
+					t.Fatalf("GetByUser = %v", conns)
+				}
+			case DuplicateLoginRejectNew:
+				if err != ErrDuplicateLogin {
+					t.Fatalf("err = %v, want ErrDuplicateLogin", err)
+				}
+				if _, ok := sm.UserOf(c2); ok {
+					t.Fatal("rejected conn should not be bound")
+				}
+			case DuplicateLoginAllowMulti:
+				if err != nil || len(sm.GetByUser("alice")) != 2 {
+					t.Fatalf("err = %v, conns = %d", err, len(sm.GetByUser("alice")))
+				}
+			}
+
+			sm.Unbind(c1)
+			sm.Unbind(c2)
+			if sm.Len() != 0 {
+				t.Fatalf("Len = %d after unbind", sm.Len())
+			}
+		})
+	}
+}
@@ -0,0 +1,380 @@
+package znet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/dokidokikoi/my-zinx/utils"
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+// 会话存储的方式
+const (
+	// 保存在内存中
+	SessionStoreMemory = "memory"
+	// 保存在 SessionStoreDir 目录下的快照和追加日志中
+	SessionStoreFile = "file"
+)
+
+// 按配置创建会话存储，未配置时返回 nil
+func newSessionStoreFromConfig() (ziface.ISessionStore, error) {
+	switch utils.GlobalObject.SessionStore {
+	case SessionStoreMemory:
+		return NewMemorySessionStore(), nil
+	case SessionStoreFile:
+		store, err := NewFileSessionStore(utils.GlobalObject.SessionStoreDir)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+	return nil, nil
+}
+
+// 复制会话，存储内外不共享属性表
+func copySessionData(data *ziface.SessionData) *ziface.SessionData {
+	properties := make(map[string]interface{}, len(data.Properties))
+	for key, value := range data.Properties {
+		properties[key] = value
+	}
+	return &ziface.SessionData{User: data.User, Properties: properties, DetachedAt: data.DetachedAt}
+}
+
+// ISessionStore 的内存实现
+type MemorySessionStore struct {
+	sessions map[string]*ziface.SessionData
+	lock     sync.RWMutex
+}
+
+func (ms *MemorySessionStore) Save(session string, data *ziface.SessionData) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	ms.sessions[session] = copySessionData(data)
+	return nil
+}
+
+func (ms *MemorySessionStore) SetProperty(session string, key string, value interface{}) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	if data, ok := ms.sessions[session]; ok {
+		data.Properties[key] = value
+	}
+	return nil
+}
+
+func (ms *MemorySessionStore) RemoveProperty(session string, key string) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	if data, ok := ms.sessions[session]; ok {
+		delete(data.Properties, key)
+	}
+	return nil
+}
+
+func (ms *MemorySessionStore) Load(session string) (*ziface.SessionData, error) {
+	ms.lock.RLock()
+	defer ms.lock.RUnlock()
+
+	data, ok := ms.sessions[session]
+	if !ok {
+		return nil, nil
+	}
+	return copySessionData(data), nil
+}
+
+func (ms *MemorySessionStore) Delete(session string) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	delete(ms.sessions, session)
+	return nil
+}
+
+func (ms *MemorySessionStore) Range(fn func(session string, data *ziface.SessionData) bool) error {
+	ms.lock.RLock()
+	sessions := make(map[string]*ziface.SessionData, len(ms.sessions))
+	for session, data := range ms.sessions {
+		sessions[session] = copySessionData(data)
+	}
+	ms.lock.RUnlock()
+
+	for session, data := range sessions {
+		if !fn(session, data) {
+			break
+		}
+	}
+	return nil
+}
+
+func (ms *MemorySessionStore) Close() error {
+	return nil
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions: make(map[string]*ziface.SessionData),
+	}
+}
+
+// 会话存储文件
+const (
+	sessionSnapshotFile = "sessions.snapshot"
+	sessionLogFile      = "sessions.log"
+)
+
+// 追加日志中的记录数超过该值时写入新的快照并清空日志
+const sessionLogCompactLen = 1024
+
+// 追加日志中的操作类型
+const (
+	sessionOpSave byte = iota
+	sessionOpSetProperty
+	sessionOpRemoveProperty
+	sessionOpDelete
+)
+
+// 追加日志中的一条记录，每条记录单独使用 gob 编码，前面是 uint32(小端序) 的记录长度
+// 属性值为自定义类型时需要先调用 gob.Register 注册，否则该属性不会被保存
+type sessionLogRecord struct {
+	Op      byte
+	Session string
+	Key     string
+	Value   interface{}
+	Data    *ziface.SessionData
+}
+
+// ISessionStore 的文件实现
+// 全部会话保存在内存中，修改时先写入追加日志，日志过长时写入快照并清空日志，
+// 创建时从快照和日志恢复会话
+type FileSessionStore struct {
+	dir     string
+	mem     *MemorySessionStore
+	log     *os.File
+	logLen  int
+	logLock sync.Mutex
+}
+
+// 将记录写入追加日志，并应用到内存中
+func (fs *FileSessionStore) append(record *sessionLogRecord) error {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, 4))
+	if err := gob.NewEncoder(&buf).Encode(record); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	binary.LittleEndian.PutUint32(data, uint32(len(data)-4))
+
+	fs.logLock.Lock()
+	defer fs.logLock.Unlock()
+
+	if fs.log == nil {
+		return os.ErrClosed
+	}
+	if _, err := fs.log.Write(data); err != nil {
+		return err
+	}
+	fs.apply(record)
+	fs.logLen++
+	if fs.logLen >= sessionLogCompactLen {
+		return fs.compact()
+	}
+	return nil
+}
+
+// 将记录应用到内存中
+func (fs *FileSessionStore) apply(record *sessionLogRecord) {
+	switch record.Op {
+	case sessionOpSave:
+		if record.Data != nil {
+			_ = fs.mem.Save(record.Session, record.Data)
+		}
+	case sessionOpSetProperty:
+		_ = fs.mem.SetProperty(record.Session, record.Key, record.Value)
+	case sessionOpRemoveProperty:
+		_ = fs.mem.RemoveProperty(record.Session, record.Key)
+	case sessionOpDelete:
+		_ = fs.mem.Delete(record.Session)
+	}
+}
+
+// 写入快照并清空追加日志，调用方需持有 logLock
+func (fs *FileSessionStore) compact() error {
+	fs.mem.lock.RLock()
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(fs.mem.sessions)
+	fs.mem.lock.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	// 先写临时文件再替换，避免写入过程中进程退出导致快照损坏
+	path := filepath.Join(fs.dir, sessionSnapshotFile)
+	if err := os.WriteFile(path+".tmp", buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	if err := fs.log.Truncate(0); err != nil {
+		return err
+	}
+	fs.logLen = 0
+	return nil
+}
+
+// 从快照和追加日志恢复会话，返回追加日志中完整记录的长度
+func (fs *FileSessionStore) load() (int64, error) {
+	snapshot, err := os.ReadFile(filepath.Join(fs.dir, sessionSnapshotFile))
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	if len(snapshot) > 0 {
+		if err := gob.NewDecoder(bytes.NewReader(snapshot)).Decode(&fs.mem.sessions); err != nil {
+			return 0, fmt.Errorf("decode session snapshot: %w", err)
+		}
+		for _, data := range fs.mem.sessions {
+			if data.Properties == nil {
+				data.Properties = make(map[string]interface{})
+			}
+		}
+	}
+
+	log, err := os.ReadFile(filepath.Join(fs.dir, sessionLogFile))
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	var offset int64
+	for len(log) >= 4 {
+		size := binary.LittleEndian.Uint32(log)
+		if uint64(len(log)-4) < uint64(size) {
+			break
+		}
+		var record sessionLogRecord
+		if err := gob.NewDecoder(bytes.NewReader(log[4 : 4+size])).Decode(&record); err != nil {
+			return 0, fmt.Errorf("decode session log: %w", err)
+		}
+		fs.apply(&record)
+		fs.logLen++
+		offset += int64(4 + size)
+		log = log[4+size:]
+	}
+	return offset, nil
+}
+
+// 部分属性无法使用 gob 编码时 Save 返回的错误，其余属性和会话已经保存
+type SessionPropertyError struct {
+	// 没有保存的属性
+	Keys []string
+	// 其中一个属性的编码错误
+	Err error
+}
+
+func (e *SessionPropertyError) Error() string {
+	return fmt.Sprintf("session properties %v not saved: %v", e.Keys, e.Err)
+}
+
+func (e *SessionPropertyError) Unwrap() error {
+	return e.Err
+}
+
+// 检查属性值能否写入追加日志，自定义类型未调用 gob.Register 时返回错误
+func gobEncodable(value interface{}) error {
+	return gob.NewEncoder(io.Discard).Encode(&sessionLogRecord{Value: value})
+}
+
+// 逐个检查属性，一个属性无法编码时只跳过该属性，其余属性和会话照常保存
+func (fs *FileSessionStore) Save(session string, data *ziface.SessionData) error {
+	var skipped *SessionPropertyError
+	saved := copySessionData(data)
+	for key, value := range saved.Properties {
+		if err := gobEncodable(value); err != nil {
+			if skipped == nil {
+				skipped = &SessionPropertyError{Err: err}
+			}
+			skipped.Keys = append(skipped.Keys, key)
+			delete(saved.Properties, key)
+		}
+	}
+
+	if err := fs.append(&sessionLogRecord{Op: sessionOpSave, Session: session, Data: saved}); err != nil {
+		return err
+	}
+	if skipped != nil {
+		sort.Strings(skipped.Keys)
+		return skipped
+	}
+	return nil
+}
+
+func (fs *FileSessionStore) SetProperty(session string, key string, value interface{}) error {
+	return fs.append(&sessionLogRecord{Op: sessionOpSetProperty, Session: session, Key: key, Value: value})
+}
+
+func (fs *FileSessionStore) RemoveProperty(session string, key string) error {
+	return fs.append(&sessionLogRecord{Op: sessionOpRemoveProperty, Session: session, Key: key})
+}
+
+func (fs *FileSessionStore) Load(session string) (*ziface.SessionData, error) {
+	return fs.mem.Load(session)
+}
+
+func (fs *FileSessionStore) Delete(session string) error {
+	return fs.append(&sessionLogRecord{Op: sessionOpDelete, Session: session})
+}
+
+func (fs *FileSessionStore) Range(fn func(session string, data *ziface.SessionData) bool) error {
+	return fs.mem.Range(fn)
+}
+
+// 写入快照后关闭追加日志
+func (fs *FileSessionStore) Close() error {
+	fs.logLock.Lock()
+	defer fs.logLock.Unlock()
+
+	if fs.log == nil {
+		return nil
+	}
+	err := fs.compact()
+	if closeErr := fs.log.Close(); err == nil {
+		err = closeErr
+	}
+	fs.log = nil
+	return err
+}
+
+// 创建文件存储，并从 dir 目录下的快照和追加日志恢复会话
+func NewFileSessionStore(dir string) (*FileSessionStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	fs := &FileSessionStore{
+		dir: dir,
+		mem: NewMemorySessionStore(),
+	}
+	offset, err := fs.load()
+	if err != nil {
+		return nil, err
+	}
+
+	log, err := os.OpenFile(filepath.Join(dir, sessionLogFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// 去掉日志末尾不完整的记录，之后从完整记录的末尾继续追加
+	if err := log.Truncate(offset); err != nil {
+		log.Close()
+		return nil, err
+	}
+	fs.log = log
+	return fs, nil
+}
@@ -0,0 +1,149 @@
+package znet
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+func TestSessionStore(t *testing.T) {
+	stores := map[string]func() ziface.ISessionStore{
+		"memory": func() ziface.ISessionStore { return NewMemorySessionStore() },
+		"file": func() ziface.ISessionStore {
+			store, err := NewFileSessionStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store
+		},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := newStore()
+			defer store.Close()
+
+			props := map[string]interface{}{"level": 3}
+			if err := store.Save("s1", &ziface.SessionData{User: "alice", Properties: props}); err != nil {
+				t.Fatal(err)
+			}
+			// 保存后修改传入的属性表不影响存储
+			props["level"] = 4
+			_ = store.SetProperty("s1", "nick", "al")
+			_ = store.RemoveProperty("s1", "level")
+			_ = store.SetProperty("missing", "nick", "bob")
+
+			data, err := store.Load("s1")
+			if err != nil || data == nil || data.User != "alice" || data.Properties["nick"] != "al" || len(data.Properties) != 1 {
+				t.Fatalf("Load = %+v, %v", data, err)
+			}
+			if data, _ := store.Load("missing"); data != nil {
+				t.Fatalf("Load missing = %+v", data)
+			}
+
+			_ = store.Delete("s1")
+			var n int
+			_ = store.Range(func(string, *ziface.SessionData) bool { n++; return true })
+			if n != 0 {
+				t.Fatalf("Range found %d sessions after delete", n)
+			}
+		})
+	}
+}
+
+func TestFileSessionStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileSessionStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	detachedAt := time.Now().Round(0)
+	_ = store.Save("s1", &ziface.SessionData{User: "alice", Properties: map[string]interface{}{"level": 3}})
+	_ = store.Save("s2", &ziface.SessionData{User: "bob", DetachedAt: detachedAt})
+	_ = store.SetProperty("s1", "nick", "al")
+	_ = store.Delete("s2")
+	// 不关闭存储，模拟进程直接退出，并在日志末尾留下不完整的记录
+	f, err := os.OpenFile(filepath.Join(dir, sessionLogFile), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{100, 0, 0, 0, 1})
+	f.Close()
+
+	check := func(store *FileSessionStore) {
+		t.Helper()
+		data, err := store.Load("s1")
+		if err != nil || data == nil || data.Properties["level"] != 3 || data.Properties["nick"] != "al" {
+			t.Fatalf("Load s1 = %+v, %v", data, err)
+		}
+		if data, _ := store.Load("s2"); data != nil {
+			t.Fatalf("Load s2 = %+v, want deleted", data)
+		}
+	}
+
+	store, err = NewFileSessionStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(store)
+
+	// 关闭时写入快照，再次打开后从快照恢复
+	_ = store.Save("s3", &ziface.SessionData{User: "carol", DetachedAt: detachedAt})
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, sessionLogFile)); err != nil || info.Size() != 0 {
+		t.Fatalf("log not truncated after close: %v, %v", info, err)
+	}
+	store, err = NewFileSessionStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	check(store)
+	if data, _ := store.Load("s3"); data == nil || !data.DetachedAt.Equal(detachedAt) {
+		t.Fatalf("Load s3 = %+v", data)
+	}
+}
+
+// 没有调用 gob.Register 注册的属性类型
+type unregisteredProperty struct {
+	N int
+}
+
+func TestFileSessionStoreUnregistered(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileSessionStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	properties := map[string]interface{}{"nick": "al", "bad": unregisteredProperty{1}, "worse": &unregisteredProperty{2}}
+
+	// 无法编码的属性被跳过并报告，其余属性照常保存
+	err = store.Save("s1", &ziface.SessionData{User: "alice", Properties: properties})
+	var propErr *SessionPropertyError
+	if !errors.As(err, &propErr) || len(propErr.Keys) != 2 || propErr.Keys[0] != "bad" || propErr.Keys[1] != "worse" {
+		t.Fatalf("Save err = %v, want bad and worse skipped", err)
+	}
+	if len(properties) != 3 {
+		t.Fatal("Save modified the caller's properties")
+	}
+	if err := store.SetProperty("s1", "bad", unregisteredProperty{3}); err == nil {
+		t.Fatal("SetProperty with an unregistered type should fail")
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewFileSessionStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if data, _ := store.Load("s1"); data == nil || len(data.Properties) != 1 || data.Properties["nick"] != "al" {
+		t.Fatalf("Load s1 = %+v, want only nick", data)
+	}
+}
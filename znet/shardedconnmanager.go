@@ -0,0 +1,198 @@
+package znet
+
+import (
+	"sync"
+
+	"github.com/dokidokikoi/my-zinx/utils"
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+// 连接管理器的一个分片
+type connShard struct {
+	connection  map[uint32]ziface.IConnection
+	connLock    sync.RWMutex
+	closedStats ziface.ConnMgrStats
+	totalConns  uint64
+}
+
+// 分片的连接管理器
+// 按 ConnID 将连接分散到多个分片，每个分片使用独立的锁，降低大量连接同时建立、断开时的锁竞争
+type ShardedConnManager struct {
+	shards []*connShard
+	// 基于连接属性的二级索引
+	indexes *connIndexes
+	// 广播时使用的编解码器
+	codec ziface.IFrameCodec
+}
+
+func (sm *ShardedConnManager) shard(connID uint32) *connShard {
+	return sm.shards[connID%uint32(len(sm.shards))]
+}
+
+func (sm *ShardedConnManager) Add(conn ziface.IConnection) {
+	shard := sm.shard(conn.GetConnID())
+	shard.connLock.Lock()
+	shard.connection[conn.GetConnID()] = conn
+	shard.totalConns++
+	shard.connLock.Unlock()
+
+	sm.indexes.add(conn)
+}
+
+// 移除连接，但并未停止连接的业务处理
+func (sm *ShardedConnManager) Remove(conn ziface.IConnection) {
+	shard := sm.shard(conn.GetConnID())
+	shard.connLock.Lock()
+	if _, ok := shard.connection[conn.GetConnID()]; ok {
+		shard.closedStats.Add(conn.Stats())
+		delete(shard.connection, conn.GetConnID())
+	}
+	shard.connLock.Unlock()
+
+	sm.indexes.remove(conn)
+}
+
+func (sm *ShardedConnManager) Get(connID uint32) (ziface.IConnection, error) {
+	shard := sm.shard(connID)
+	shard.connLock.RLock()
+	defer shard.connLock.RUnlock()
+
+	if conn, ok := shard.connection[connID]; ok {
+		return conn, nil
+	}
+	return nil, ErrConnNotFound
+}
+
+func (sm *ShardedConnManager) Len() int {
+	length := 0
+	for _, shard := range sm.shards {
+		shard.connLock.RLock()
+		length += len(shard.connection)
+		shard.connLock.RUnlock()
+	}
+	return length
+}
+
+func (sm *ShardedConnManager) ClearConn() {
+	for _, shard := range sm.shards {
+		shard.connLock.Lock()
+		for connID, conn := range shard.connection {
+			conn.StopWithReason(ziface.CloseServerShutdown, nil)
+			shard.closedStats.Add(conn.Stats())
+			delete(shard.connection, connID)
+		}
+		shard.connLock.Unlock()
+	}
+}
+
+func (sm *ShardedConnManager) Stats() ziface.ConnMgrStats {
+	var stats ziface.ConnMgrStats
+	for _, shard := range sm.shards {
+		shard.connLock.RLock()
+		stats.Merge(shard.closedStats)
+		stats.Conns += len(shard.connection)
+		stats.TotalConns += shard.totalConns
+		for _, conn := range shard.connection {
+			connStats := conn.Stats()
+			stats.Add(connStats)
+			stats.QueueLen += connStats.QueueLen
+			if connStats.Slow {
+				stats.SlowConns++
+			}
+		}
+		shard.connLock.RUnlock()
+	}
+	return stats
+}
+
+// 逐个分片获取连接快照后遍历，避免长时间持有锁
+func (sm *ShardedConnManager) Range(fn func(conn ziface.IConnection) bool) {
+	var conns []ziface.IConnection
+	for _, shard := range sm.shards {
+		shard.connLock.RLock()
+		conns = conns[:0]
+		for _, conn := range shard.connection {
+			conns = append(conns, conn)
+		}
+		shard.connLock.RUnlock()
+
+		for _, conn := range conns {
+			if !fn(conn) {
+				return
+			}
+		}
+	}
+}
+
+func (sm *ShardedConnManager) Filter(fn func(conn ziface.IConnection) bool) []ziface.IConnection {
+	var conns []ziface.IConnection
+	sm.Range(func(conn ziface.IConnection) bool {
+		if fn(conn) {
+			conns = append(conns, conn)
+		}
+		return true
+	})
+	return conns
+}
+
+func (sm *ShardedConnManager) AddIndex(property string) {
+	sm.indexes.addIndex(property, func() []ziface.IConnection {
+		return sm.Filter(func(ziface.IConnection) bool { return true })
+	})
+}
+
+func (sm *ShardedConnManager) GetByIndex(property string, value interface{}) []ziface.IConnection {
+	return sm.indexes.get(property, value)
+}
+
+func (sm *ShardedConnManager) SetCodec(codec ziface.IFrameCodec) {
+	sm.codec = codec
+}
+
+func (sm *ShardedConnManager) Broadcast(msgID uint32, data []byte) []ziface.SendResult {
+	return sm.BroadcastFilter(func(ziface.IConnection) bool { return true }, msgID, data)
+}
+
+func (sm *ShardedConnManager) Multicast(connIDs []uint32, msgID uint32, data []byte) []ziface.SendResult {
+	conns := make([]ziface.IConnection, 0, len(connIDs))
+	var missing []ziface.SendResult
+	for _, connID := range connIDs {
+		conn, err := sm.Get(connID)
+		if err != nil {
+			missing = append(missing, ziface.SendResult{ConnID: connID, Err: err})
+			continue
+		}
+		conns = append(conns, conn)
+	}
+	return append(broadcastPacked(sm.codec, conns, msgID, data), missing...)
+}
+
+func (sm *ShardedConnManager) BroadcastFilter(fn func(conn ziface.IConnection) bool, msgID uint32, data []byte) []ziface.SendResult {
+	return broadcastPacked(sm.codec, sm.Filter(fn), msgID, data)
+}
+
+// 创建分片的连接管理器，shardCount 小于 1 时按 1 处理
+func NewShardedConnManager(shardCount int) *ShardedConnManager {
+	if shardCount < 1 {
+		shardCount = 1
+	}
+	sm := &ShardedConnManager{
+		shards:  make([]*connShard, shardCount),
+		indexes: newConnIndexes(),
+		codec:   NewPacketCodec(NewDataPack()),
+	}
+	for i := range sm.shards {
+		sm.shards[i] = &connShard{
+			connection: make(map[uint32]ziface.IConnection),
+		}
+	}
+	return sm
+}
+
+// 根据配置创建连接管理器，配置了多个分片时使用分片的连接管理器
+func newConnManagerFromConfig() ziface.IConnManager {
+	if utils.GlobalObject.ConnMgrShardCount > 1 {
+		return NewShardedConnManager(utils.GlobalObject.ConnMgrShardCount)
+	}
+	return NewConnManager()
+}
@@ -0,0 +1,21 @@
+package znet
+
+import "github.com/dokidokikoi/my-zinx/utils"
+
+// 慢消费者的处理策略
+const (
+	// 跳过非关键消息
+	SlowConsumerSkip = "skip"
+	// 断开连接
+	SlowConsumerDisconnect = "disconnect"
+)
+
+// 判断 msgID 是否为慢消费者时仍需发送的关键消息
+func isCriticalMsg(msgID uint32) bool {
+	for _, id := range utils.GlobalObject.CriticalMsgIDs {
+		if id == msgID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package znet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dokidokikoi/my-zinx/utils"
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+// 输出队列高于 2 条持续 50ms 后视为慢消费者
+func setSlowConsumerConfig(t *testing.T, policy string) {
+	setTestConfig(t, func(conf *utils.GlobalObj) {
+		conf.SlowConsumerHighWater = 2
+		conf.SlowConsumerThreshold = 50
+		conf.SlowConsumerPolicy = policy
+		conf.CriticalMsgIDs = []uint32{9}
+	})
+}
+
+// 创建输出队列已经达到高水位、且持续时间超过阈值的连接
+func newSlowTestConnection(t *testing.T) *Connection {
+	conn := newTestConnection(1)
+	conn.msgBuffChan = make(chan []byte, 8)
+	for i := 0; i < 3; i++ {
+		if err := conn.SendPackedBuffMsg(1, []byte("m")); err != nil {
+			t.Fatalf("send %d before threshold: %v", i, err)
+		}
+	}
+	if conn.Stats().Slow {
+		t.Fatal("conn slow before threshold")
+	}
+	time.Sleep(60 * time.Millisecond)
+	return conn
+}
+
+func TestSlowConsumerSkip(t *testing.T) {
+	setSlowConsumerConfig(t, SlowConsumerSkip)
+	conn := newSlowTestConnection(t)
+
+	// 跳过非关键消息，关键消息仍然发送
+	if err := conn.SendPackedBuffMsg(1, []byte("m")); err == nil {
+		t.Fatal("non-critical msg sent to slow consumer")
+	}
+	if err := conn.SendPackedBuffMsg(9, []byte("m")); err != nil {
+		t.Fatalf("critical msg err = %v", err)
+	}
+	stats := conn.Stats()
+	if !stats.Slow || stats.DroppedSends != 1 || stats.QueueLen != 4 {
+		t.Fatalf("slow conn stats = %+v", stats)
+	}
+
+	// 输出队列降到高水位以下后恢复
+	for len(conn.msgBuffChan) > 0 {
+		<-conn.msgBuffChan
+	}
+	if err := conn.SendPackedBuffMsg(1, []byte("m")); err != nil || conn.Stats().Slow {
+		t.Fatalf("send after drain err = %v, slow = %v", err, conn.Stats().Slow)
+	}
+}
+
+func TestSlowConsumerDisconnect(t *testing.T) {
+	setSlowConsumerConfig(t, SlowConsumerDisconnect)
+	conn := newSlowTestConnection(t)
+
+	if err := conn.SendPackedBuffMsg(9, []byte("m")); err == nil {
+		t.Fatal("msg sent to slow consumer with disconnect policy")
+	}
+	if code := conn.CloseReason().Code; code != ziface.CloseSlowConsumer {
+		t.Fatalf("close reason = %v, want CloseSlowConsumer", code)
+	}
+}
@@ -0,0 +1,163 @@
+package znet
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+// 文本行的默认最大长度
+const defaultTextMaxLineLength = 4096
+
+var ErrLineTooLong = errors.New("text line too long")
+
+// 未知命令交给该 MsgID 的内部路由，按顺序回复错误行，命令表中不能使用该 MsgID
+const TextUnknownCommandMsgID = math.MaxUint32 - 5
+
+// 文本编解码器的配置
+type TextCodecConfig struct {
+	// 行分隔符，为 0 时使用换行符，使用换行符时会去掉行尾的 '\r'
+	Delimiter byte
+	// 一行的最大字节数，不含分隔符，超出时连接以拆包失败关闭，为 0 时使用 4096
+	MaxLineLength int
+	// 命令表，每行的第一个词作为命令映射为 MsgID，其余部分作为消息数据
+	// 键为空字符串的项用于未知命令，此时整行作为消息数据；
+	// 没有该项时回复 "ERR unknown command <命令>"，连接不关闭
+	Commands map[string]uint32
+}
+
+// 以分隔符分帧的文本编解码器，用于运维工具和 telnet、nc 等调试客户端
+// 请求按命令表交给已有的路由处理，回复的消息数据直接以文本写出并追加分隔符
+// 关闭帧、会话恢复令牌等二进制控制帧无法在文本流中表达，使用 TextCodec 时不发送关闭帧，也不开启会话恢复
+type TextCodec struct {
+	conf TextCodecConfig
+}
+
+// 检查配置并创建文本编解码器
+func NewTextCodec(conf TextCodecConfig) (*TextCodec, error) {
+	if conf.MaxLineLength < 0 {
+		return nil, fmt.Errorf("invalid max line length %d", conf.MaxLineLength)
+	}
+	if conf.Delimiter == 0 {
+		conf.Delimiter = '\n'
+	}
+	if conf.MaxLineLength == 0 {
+		conf.MaxLineLength = defaultTextMaxLineLength
+	}
+	commands := make(map[string]uint32, len(conf.Commands))
+	for cmd, msgID := range conf.Commands {
+		if bytes.IndexAny([]byte(cmd), " \t") >= 0 || bytes.IndexByte([]byte(cmd), conf.Delimiter) >= 0 {
+			return nil, fmt.Errorf("invalid command %q", cmd)
+		}
+		if msgID == TextUnknownCommandMsgID {
+			return nil, fmt.Errorf("command %q uses reserved msgID %d", cmd, msgID)
+		}
+		commands[cmd] = msgID
+	}
+	conf.Commands = commands
+	return &TextCodec{conf: conf}, nil
+}
+
+func (tc *TextCodec) Decode(r *bufio.Reader) (ziface.IMessage, error) {
+	for {
+		line, err := tc.readLine(r)
+		if err != nil {
+			return nil, err
+		}
+
+		line = bytes.TrimLeft(line, " \t")
+		if len(bytes.TrimRight(line, " \t")) == 0 {
+			// 忽略空行
+			continue
+		}
+		cmd, rest := line, []byte(nil)
+		if i := bytes.IndexAny(line, " \t"); i >= 0 {
+			cmd, rest = line[:i], bytes.TrimLeft(line[i:], " \t")
+		}
+		msgID, ok := tc.conf.Commands[string(cmd)]
+		if !ok {
+			if msgID, ok = tc.conf.Commands[""]; ok {
+				rest = line
+			} else {
+				msgID, rest = TextUnknownCommandMsgID, append([]byte("ERR unknown command "), cmd...)
+			}
+		}
+
+		if len(rest) == 0 {
+			return NewMessage(msgID, nil), nil
+		}
+		buf := getBuff(uint32(len(rest)))
+		copy(*buf, rest)
+		return &pooledMessage{IMessage: NewMessage(msgID, *buf), buf: buf}, nil
+	}
+}
+
+// 读取一行，返回的切片不含分隔符，只在下次读取前有效
+func (tc *TextCodec) readLine(r *bufio.Reader) ([]byte, error) {
+	var long []byte
+	for {
+		line, err := r.ReadSlice(tc.conf.Delimiter)
+		if err == bufio.ErrBufferFull {
+			// 行比读缓冲长，先拼接到 long 中
+			if len(long)+len(line) > tc.conf.MaxLineLength {
+				return nil, ErrLineTooLong
+			}
+			long = append(long, line...)
+			continue
+		}
+		if err == io.EOF && len(long)+len(line) > 0 {
+			// 最后一行没有分隔符时同样作为一行返回，下次读取时返回 io.EOF
+			err = nil
+		} else if err != nil {
+			return nil, err
+		} else {
+			line = line[:len(line)-1]
+		}
+
+		if long != nil {
+			line = append(long, line...)
+		}
+		if tc.conf.Delimiter == '\n' && len(line) > 0 && line[len(line)-1] == '\r' {
+			line = line[:len(line)-1]
+		}
+		if len(line) > tc.conf.MaxLineLength {
+			return nil, ErrLineTooLong
+		}
+		return line, nil
+	}
+}
+
+func (tc *TextCodec) noControlFrames() {}
+
+// 回复未知命令的错误行
+type textUnknownCommandRouter struct {
+	BaseRouter
+}
+
+func (r *textUnknownCommandRouter) Handle(request ziface.IRequest) {
+	_ = request.GetConnection().SendMsg(TextUnknownCommandMsgID, request.GetData())
+}
+
+func (tc *TextCodec) Encode(w io.Writer, msg ziface.IMessage) error {
+	data, err := tc.AppendEncode(nil, msg)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// 写出消息数据并追加分隔符，数据已经以分隔符结尾时不再追加
+func (tc *TextCodec) AppendEncode(dst []byte, msg ziface.IMessage) ([]byte, error) {
+	data := msg.GetData()
+	dst = append(dst, data...)
+	if len(data) == 0 || data[len(data)-1] != tc.conf.Delimiter {
+		dst = append(dst, tc.conf.Delimiter)
+	}
+	return dst, nil
+}
@@ -0,0 +1,150 @@
+package znet
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dokidokikoi/my-zinx/utils"
+)
+
+func TestTextCodecDecode(t *testing.T) {
+	codec, err := NewTextCodec(TextCodecConfig{
+		Commands: map[string]uint32{"ping": 0, "hello": 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 最后一行没有分隔符
+	input := "ping\r\n\n  hello  zinx text \r\nunknown cmd\nhello\nhello last"
+	r := bufio.NewReader(strings.NewReader(input))
+	want := []struct {
+		msgID uint32
+		data  string
+	}{{0, ""}, {1, "zinx text "}, {TextUnknownCommandMsgID, "ERR unknown command unknown"}, {1, ""}, {1, "last"}}
+	for _, w := range want {
+		msg, err := codec.Decode(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.GetMsgID() != w.msgID || string(msg.GetData()) != w.data {
+			t.Fatalf("Decode = %d %q, want %d %q", msg.GetMsgID(), msg.GetData(), w.msgID, w.data)
+		}
+		if pm, ok := msg.(*pooledMessage); ok {
+			putBuff(pm.buf)
+		}
+	}
+	if _, err := codec.Decode(r); err != io.EOF {
+		t.Fatalf("Decode at end err = %v, want io.EOF", err)
+	}
+}
+
+func TestTextCodecUnknownCommand(t *testing.T) {
+	codec, err := NewTextCodec(TextCodecConfig{
+		Delimiter: ';',
+		Commands:  map[string]uint32{"ping": 0, "": 99},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := codec.Decode(bufio.NewReader(strings.NewReader("help me;")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.GetMsgID() != 99 || string(msg.GetData()) != "help me" {
+		t.Fatalf("Decode = %d %q, want 99 %q", msg.GetMsgID(), msg.GetData(), "help me")
+	}
+}
+
+func TestTextCodecMaxLineLength(t *testing.T) {
+	codec, err := NewTextCodec(TextCodecConfig{
+		MaxLineLength: 32,
+		Commands:      map[string]uint32{"echo": 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 超过读缓冲长度的行也要能正确拼接
+	long := "echo " + strings.Repeat("a", 27) + "\n"
+	r := bufio.NewReaderSize(strings.NewReader(long+long+"echo "+strings.Repeat("b", 28)+"\n"), 16)
+	for i := 0; i < 2; i++ {
+		msg, err := codec.Decode(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msg.GetData()) != 27 {
+			t.Fatalf("Decode data len = %d, want 27", len(msg.GetData()))
+		}
+	}
+	if _, err := codec.Decode(r); err != ErrLineTooLong || isReadError(err) {
+		t.Fatalf("Decode long line err = %v, want ErrLineTooLong", err)
+	}
+}
+
+func TestTextCodecEncode(t *testing.T) {
+	codec, _ := NewTextCodec(TextCodecConfig{})
+	var out bytes.Buffer
+	codec.Encode(&out, NewMessage(1, []byte("pong")))
+	codec.Encode(&out, NewMessage(1, []byte("done\n")))
+	codec.Encode(&out, NewMessage(1, nil))
+	if out.String() != "pong\ndone\n\n" {
+		t.Fatalf("Encode = %q", out.String())
+	}
+
+	if _, err := NewTextCodec(TextCodecConfig{Commands: map[string]uint32{"two words": 1}}); err == nil {
+		t.Fatal("NewTextCodec with a spaced command should fail")
+	}
+	if _, err := NewTextCodec(TextCodecConfig{Commands: map[string]uint32{"x": TextUnknownCommandMsgID}}); err == nil {
+		t.Fatal("NewTextCodec with the reserved msgID should fail")
+	}
+}
+
+func TestTextCodecServer(t *testing.T) {
+	setTestConfig(t, func(conf *utils.GlobalObj) {
+		conf.SendCloseFrame = true
+		conf.ResumeEnable = true
+	})
+	codec, err := NewTextCodec(TextCodecConfig{Commands: map[string]uint32{"echo": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, WithCodec(codec))
+	s.AddRouter(1, &testEchoRouter{})
+	if s.resumeMgr != nil {
+		t.Fatal("resume enabled with text codec")
+	}
+
+	client, conn := dialTestServer(t, s)
+	r := bufio.NewReader(client)
+	readLine := func() string {
+		t.Helper()
+		_ = client.SetReadDeadline(time.Now().Add(3 * time.Second))
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		return line
+	}
+	// 未知命令回复错误后连接仍可以继续使用
+	if _, err := client.Write([]byte("echo hello zinx\r\nbogus 1\necho again\n")); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"hello zinx\n", "ERR unknown command bogus\n", "again\n"} {
+		if line := readLine(); line != want {
+			t.Fatalf("reply %q, want %q", line, want)
+		}
+	}
+
+	// 关闭时不在文本流中写入二进制的关闭帧
+	conn.Stop()
+	_ = client.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if rest, err := io.ReadAll(r); err != nil || len(rest) != 0 {
+		t.Fatalf("read after stop = %q, %v, want EOF", rest, err)
+	}
+}
@@ -0,0 +1,95 @@
+package znet
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/dokidokikoi/my-zinx/utils"
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+// uint32 编码为 varint 后的最大字节数
+const maxVarintLen32 = 5
+
+var (
+	ErrVarintOverlong = errors.New("overlong varint")
+	ErrVarintOverflow = errors.New("varint overflows uint32")
+)
+
+// 头部使用变长整数的编解码器，依次写 DataLen、MsgID 的 uvarint 编码和消息数据
+// 编码方式与 encoding/binary 的 Uvarint 相同，小消息和小 MsgID 的头部只需 2 字节
+type VarintCodec struct{}
+
+func (vc *VarintCodec) Decode(r *bufio.Reader) (ziface.IMessage, error) {
+	dataLen, err := readUvarint32(r, true)
+	if err != nil {
+		return nil, err
+	}
+	// 读取 msgID 之前先检查长度，避免按超长的长度分配缓冲
+	if utils.GlobalObject.MaxPacketSize > 0 && dataLen > utils.GlobalObject.MaxPacketSize {
+		return nil, errors.New("Too Large msg data received")
+	}
+	msgID, err := readUvarint32(r, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if dataLen == 0 {
+		return NewMessage(msgID, nil), nil
+	}
+	buf := getBuff(dataLen)
+	if _, err := io.ReadFull(r, *buf); err != nil {
+		putBuff(buf)
+		return nil, err
+	}
+	return &pooledMessage{IMessage: NewMessage(msgID, *buf), buf: buf}, nil
+}
+
+// 从 r 中读取一个 uint32 的 uvarint，拒绝超过 5 字节、溢出 uint32 和非最短形式的编码
+// first 为 true 时表示这是一帧的第一个字节，此时读到 EOF 原样返回，否则视为帧被截断
+func readUvarint32(r io.ByteReader, first bool) (uint32, error) {
+	var x uint32
+	for i := 0; i < maxVarintLen32; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF && (i > 0 || !first) {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		if i == maxVarintLen32-1 && b > 0x0f {
+			return 0, ErrVarintOverflow
+		}
+		if b < 0x80 {
+			// 多字节编码的最后一个字节为 0 时不是最短形式
+			if i > 0 && b == 0 {
+				return 0, ErrVarintOverlong
+			}
+			return x | uint32(b)<<(7*i), nil
+		}
+		x |= uint32(b&0x7f) << (7 * i)
+	}
+	return 0, ErrVarintOverlong
+}
+
+func (vc *VarintCodec) Encode(w io.Writer, msg ziface.IMessage) error {
+	data, err := vc.AppendEncode(make([]byte, 0, 2*maxVarintLen32+len(msg.GetData())), msg)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// 将 msg 编码后追加到 dst 尾部，dst 容量足够时不会产生内存分配
+func (vc *VarintCodec) AppendEncode(dst []byte, msg ziface.IMessage) ([]byte, error) {
+	dst = binary.AppendUvarint(dst, uint64(len(msg.GetData())))
+	dst = binary.AppendUvarint(dst, uint64(msg.GetMsgID()))
+	return append(dst, msg.GetData()...), nil
+}
+
+func NewVarintCodec() *VarintCodec {
+	return &VarintCodec{}
+}
@@ -0,0 +1,162 @@
+package znet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/dokidokikoi/my-zinx/utils"
+	"github.com/dokidokikoi/my-zinx/ziface"
+)
+
+func TestVarintCodec(t *testing.T) {
+	codec := NewVarintCodec()
+	var stream bytes.Buffer
+	msgs := []struct {
+		msgID uint32
+		data  string
+	}{{1, "hello"}, {300, ""}, {math.MaxUint32, string(make([]byte, 200))}}
+	for _, m := range msgs {
+		if err := codec.Encode(&stream, NewMessage(m.msgID, []byte(m.data))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 最小的帧头部只有 2 字节
+	if stream.Bytes()[0] != 5 || stream.Bytes()[1] != 1 {
+		t.Fatalf("first frame head = % x", stream.Bytes()[:2])
+	}
+	// 截断的最后一帧
+	packed, _ := packMessage(codec, 4, []byte("partial"))
+	stream.Write(packed[:4])
+
+	r := bufio.NewReader(&stream)
+	for _, m := range msgs {
+		msg, err := codec.Decode(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.GetMsgID() != m.msgID || string(msg.GetData()) != m.data {
+			t.Fatalf("Decode = %d %q, want %d %q", msg.GetMsgID(), msg.GetData(), m.msgID, m.data)
+		}
+		if pm, ok := msg.(*pooledMessage); ok {
+			putBuff(pm.buf)
+		}
+	}
+	if _, err := codec.Decode(r); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Decode truncated frame err = %v, want io.ErrUnexpectedEOF", err)
+	}
+	if _, err := codec.Decode(r); err != io.EOF {
+		t.Fatalf("Decode at end err = %v, want io.EOF", err)
+	}
+}
+
+func TestVarintCodecInvalid(t *testing.T) {
+	oldMax := utils.GlobalObject.MaxPacketSize
+	defer func() { utils.GlobalObject.MaxPacketSize = oldMax }()
+	utils.GlobalObject.MaxPacketSize = 100
+
+	tooLarge := binary.AppendUvarint(nil, 101)
+	cases := map[string][]byte{
+		"overlong":       {0x81, 0x00, 0x01},
+		"too many":       {0x80, 0x80, 0x80, 0x80, 0x80, 0x01, 0x01},
+		"overflow":       {0xff, 0xff, 0xff, 0xff, 0x1f, 0x01},
+		"too large":      append(tooLarge, 1),
+		"msgID overlong": {0x00, 0x80, 0x80, 0x00},
+	}
+	codec := NewVarintCodec()
+	for name, frame := range cases {
+		_, err := codec.Decode(bufio.NewReader(bytes.NewReader(frame)))
+		if err == nil || isReadError(err) {
+			t.Errorf("%s: Decode err = %v, want a format error", name, err)
+		}
+	}
+}
+
+func BenchmarkVarintCodecAppendEncode(b *testing.B) {
+	codec := NewVarintCodec()
+	msg := NewMessage(1, make([]byte, 64))
+	buf := make([]byte, 0, 128)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf, _ = codec.AppendEncode(buf[:0], msg)
+	}
+}
+
+func BenchmarkDataPackCodecAppendEncode(b *testing.B) {
+	codec := NewPacketCodec(NewDataPack())
+	msg := NewMessage(1, make([]byte, 64))
+	buf := make([]byte, 0, 128)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf, _ = codec.AppendEncode(buf[:0], msg)
+	}
+}
+
+// 从循环读取同一帧的 Reader 中解码
+func benchmarkDecode(b *testing.B, codec ziface.IFrameCodec) {
+	packed, _ := packMessage(codec, 1, make([]byte, 64))
+	r := bufio.NewReader(&repeatReader{data: packed})
+	b.SetBytes(int64(len(packed)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		msg, err := codec.Decode(r)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if pm, ok := msg.(*pooledMessage); ok {
+			putBuff(pm.buf)
+		}
+	}
+}
+
+func BenchmarkVarintCodecDecode(b *testing.B) {
+	benchmarkDecode(b, NewVarintCodec())
+}
+
+func BenchmarkDataPackCodecDecode(b *testing.B) {
+	benchmarkDecode(b, NewPacketCodec(NewDataPack()))
+}
+
+type repeatReader struct {
+	data []byte
+	off  int
+}
+
+func (rr *repeatReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		c := copy(p[n:], rr.data[rr.off:])
+		n += c
+		rr.off = (rr.off + c) % len(rr.data)
+	}
+	return n, nil
+}
+
+func TestVarintCodecServer(t *testing.T) {
+	codec := NewVarintCodec()
+	s := newTestServer(t, WithCodec(codec))
+	s.AddRouter(300, &testEchoRouter{})
+	if s.Codec() != codec {
+		t.Fatalf("Codec = %T, want the VarintCodec", s.Codec())
+	}
+	// 旧的 Packet 接口仍然可以封包
+	packed, err := s.Packet().Pack(NewMessage(300, []byte("hi")))
+	if want, _ := packMessage(codec, 300, []byte("hi")); err != nil || !bytes.Equal(packed, want) {
+		t.Fatalf("Packet().Pack = % x, %v, want % x", packed, err, want)
+	}
+	if _, err := s.Packet().Unpack(packed); err != ErrPacketUnpackUnsupported {
+		t.Fatalf("Packet().Unpack err = %v, want ErrPacketUnpackUnsupported", err)
+	}
+
+	client, _ := dialTestServer(t, s)
+	r := bufio.NewReader(client)
+	for _, data := range []string{"hi", string(make([]byte, 1000))} {
+		writeTestFrame(t, client, codec, 300, []byte(data))
+		if msgID, got := readTestFrame(t, client, r, codec); msgID != 300 || got != data {
+			t.Fatalf("echo = %d %q, want 300 %q", msgID, got, data)
+		}
+	}
+}